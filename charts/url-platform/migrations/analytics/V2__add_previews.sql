@@ -0,0 +1,4 @@
+-- Link previews (redirect-service /r/{code}+) are counted separately so
+-- they never inflate the redirect count.
+ALTER TABLE analytics
+    ADD COLUMN IF NOT EXISTS previews BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Counts are kept per code namespace (redirect-service TENANTS), since
+-- codes are only unique within one. Existing rows belong to the default
+-- namespace, ''.
+ALTER TABLE analytics
+    ADD COLUMN IF NOT EXISTS namespace TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE analytics DROP CONSTRAINT IF EXISTS analytics_pkey;
+ALTER TABLE analytics ADD PRIMARY KEY (namespace, code);
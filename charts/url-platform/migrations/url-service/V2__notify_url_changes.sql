@@ -0,0 +1,18 @@
+-- Announce every change to a short code on the url_changes channel so
+-- redirect-service replicas can evict it from their resolve caches.
+-- The payload is the affected code. Inserts are included so a code that
+-- was cached as not-found becomes reachable immediately.
+CREATE OR REPLACE FUNCTION notify_url_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('url_changes', OLD.code);
+    ELSE
+        PERFORM pg_notify('url_changes', NEW.code);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER urls_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON urls
+    FOR EACH ROW EXECUTE FUNCTION notify_url_change();
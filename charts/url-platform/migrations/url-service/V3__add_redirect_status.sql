@@ -0,0 +1,5 @@
+-- Per-link redirect status read by redirect-service (301, 302, 307 or 308).
+-- NULL means the redirect-service default (DEFAULT_REDIRECT_STATUS).
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS redirect_status SMALLINT
+        CHECK (redirect_status IN (301, 302, 307, 308));
@@ -0,0 +1,8 @@
+-- Optional activation window read by redirect-service. Before not_before a
+-- link is treated as not found; from expires_at on it returns 410 Gone, or
+-- redirects to fallback_url when one is set. NULL leaves that side open.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS not_before   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS expires_at   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS fallback_url TEXT,
+    ADD CONSTRAINT urls_window_check CHECK (expires_at > not_before);
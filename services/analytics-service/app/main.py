@@ -6,12 +6,12 @@ import os
 import sys
 import time
 from datetime import datetime, timezone
-from typing import Any, Dict, Optional
+from typing import Any, Dict, Literal, Optional
 
 import psycopg2
 import psycopg2.extras
 import psycopg2.pool
-from fastapi import FastAPI, Request, Response
+from fastapi import FastAPI, Query, Request, Response
 from fastapi.middleware.cors import CORSMiddleware
 from fastapi.responses import JSONResponse
 from pydantic import BaseModel, Field, HttpUrl
@@ -134,11 +134,24 @@ def get_db():
         _get_pool().putconn(conn)
 
 
+NAMESPACE_PATTERN = r"^[A-Za-z0-9._-]*$"
+# Upper bound for GET /stats?limit=, e.g. redirect-service's cache warm-up.
+STATS_MAX_LIMIT = 1000
+
+
 class RedirectEvent(BaseModel):
     code: str = Field(min_length=1, max_length=64)
+    # Code namespace (redirect-service TENANTS); "" is the default one.
+    tenant: str = Field(default="", max_length=64, pattern=NAMESPACE_PATTERN)
+    # "preview" events come from link preview pages and are not clicks.
+    type: Literal["click", "preview"] = "click"
     ts: Optional[int] = Field(default=None, description="Unix timestamp (seconds). Optional.")
     user_agent: Optional[str] = Field(default=None, max_length=256)
     referrer: Optional[HttpUrl] = None
+    # A/B variant or targeting rule the visitor was sent to, and their
+    # ISO 3166-1 country when redirect-service has GeoIP. Logged only.
+    variant: Optional[str] = Field(default=None, max_length=64)
+    country: Optional[str] = Field(default=None, min_length=2, max_length=2)
 
 
 setup_tracing()
@@ -282,56 +295,84 @@ async def ready() -> Dict[str, str]:
 async def ingest_event(evt: RedirectEvent, request: Request) -> Dict[str, Any]:
     # UPSERT: increment count if code exists, insert with count=1 if not.
     # ON CONFLICT is atomic - no race condition between check and insert.
+    # Previews increment their own column and leave the click count alone.
+    column = "previews" if evt.type == "preview" else "count"
     with get_db() as conn:
         with conn:
             with conn.cursor() as cur:
                 cur.execute(
-                    """
-                    INSERT INTO analytics (code, count)
-                    VALUES (%s, 1)
-                    ON CONFLICT (code) DO UPDATE
-                        SET count = analytics.count + 1
+                    f"""
+                    INSERT INTO analytics (namespace, code, {column})
+                    VALUES (%s, %s, 1)
+                    ON CONFLICT (namespace, code) DO UPDATE
+                        SET {column} = analytics.{column} + 1
                     """,
-                    (evt.code,),
+                    (evt.tenant, evt.code),
                 )
 
-    logger.info("event_accepted", extra={"request_id": _rid(request), "code": evt.code})
+    logger.info("event_accepted", extra={
+        "request_id": _rid(request),
+        "tenant": evt.tenant,
+        "code": evt.code,
+        "type": evt.type,
+        "variant": evt.variant,
+        "country": evt.country,
+    })
     return {"accepted": True, "code": evt.code}
 
 
 @app.get("/stats")
-async def stats(request: Request) -> Dict[str, Any]:
+async def stats(
+    request: Request,
+    limit: int = Query(default=20, ge=1, le=STATS_MAX_LIMIT),
+) -> Dict[str, Any]:
     with get_db() as conn:
         with conn.cursor(cursor_factory=psycopg2.extras.RealDictCursor) as cur:
+            # Codes that have only been previewed have no redirects to rank.
             cur.execute(
-                "SELECT code, count FROM analytics ORDER BY count DESC LIMIT 20"
+                "SELECT namespace, code, count FROM analytics WHERE count > 0 ORDER BY count DESC LIMIT %s",
+                (limit,),
             )
             rows = cur.fetchall()
-            cur.execute("SELECT COUNT(*) AS total FROM analytics")
+            cur.execute("SELECT COUNT(*) AS total FROM analytics WHERE count > 0")
             total = cur.fetchone()["total"]
 
     logger.info("stats_top", extra={"request_id": _rid(request), "tracked_codes": total})
     return {
         "uptime_seconds": int(time.time() - _started_at),
         "tracked_codes": total,
-        "top": [{"code": r["code"], "count": r["count"]} for r in rows],
+        "top": [{"namespace": r["namespace"], "code": r["code"], "count": r["count"]} for r in rows],
     }
 
 
 @app.get("/stats/{code}")
-async def stats_code(code: str, request: Request) -> Dict[str, Any]:
+async def stats_code(
+    code: str,
+    request: Request,
+    namespace: str = Query(default="", max_length=64, pattern=NAMESPACE_PATTERN),
+) -> Dict[str, Any]:
     if not (1 <= len(code) <= 64):
         logger.info("invalid_code", extra={"request_id": _rid(request), "code": code})
         return JSONResponse(status_code=400, content={"error": "invalid_code"})
 
     with get_db() as conn:
         with conn.cursor(cursor_factory=psycopg2.extras.RealDictCursor) as cur:
-            cur.execute("SELECT count FROM analytics WHERE code = %s", (code,))
+            cur.execute(
+                "SELECT count, previews FROM analytics WHERE namespace = %s AND code = %s",
+                (namespace, code),
+            )
             row = cur.fetchone()
 
     count = int(row["count"]) if row else 0
-    logger.info("stats_code", extra={"request_id": _rid(request), "code": code, "count": count})
-    return {"code": code, "count": count}
+    previews = int(row["previews"]) if row else 0
+    logger.info("stats_code", extra={
+        "request_id": _rid(request),
+        "tenant": namespace,
+        "code": code,
+        "count": count,
+        "previews": previews,
+    })
+    return {"code": code, "count": count, "previews": previews}
 
 
 @app.exception_handler(Exception)
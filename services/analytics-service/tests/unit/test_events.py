@@ -68,13 +68,85 @@ def test_event_increments_stats():
     assert r1.status_code == 202
 
     # Simulate: code now has count=1 in DB
-    conn_get2, _ = make_mock_conn(fetchone_return={"count": 1})
+    conn_get2, _ = make_mock_conn(fetchone_return={"count": 1, "previews": 0})
     with patch("app.main.get_db", mock_get_db(conn_get2)):
         r2 = client.get(f"/stats/{code}")
     assert r2.status_code == 200
     assert r2.json()["count"] == 1
 
 
+def test_preview_event_is_not_a_click():
+    conn_post, cur = make_mock_conn()
+    with patch("app.main.get_db", mock_get_db(conn_post)):
+        r = client.post("/events", json={"code": "abc123", "type": "preview"})
+    assert r.status_code == 202
+    sql = cur.execute.call_args[0][0]
+    assert "previews = analytics.previews + 1" in sql
+    assert "count" not in sql
+
+    conn_post, cur = make_mock_conn()
+    with patch("app.main.get_db", mock_get_db(conn_post)):
+        r = client.post("/events", json={"code": "abc123", "type": "click"})
+    assert r.status_code == 202
+    assert "count = analytics.count + 1" in cur.execute.call_args[0][0]
+
+    conn_get, _ = make_mock_conn(fetchone_return={"count": 0, "previews": 1})
+    with patch("app.main.get_db", mock_get_db(conn_get)):
+        r = client.get("/stats/abc123")
+    assert r.json() == {"code": "abc123", "count": 0, "previews": 1}
+
+
+def test_event_is_counted_per_namespace():
+    conn_post, cur = make_mock_conn()
+    with patch("app.main.get_db", mock_get_db(conn_post)):
+        r = client.post("/events", json={
+            "code": "abc123", "tenant": "brand-b", "variant": "control", "country": "DE",
+        })
+    assert r.status_code == 202
+    sql, params = cur.execute.call_args[0]
+    assert "ON CONFLICT (namespace, code)" in sql
+    assert params == ("brand-b", "abc123")
+
+    conn_post, cur = make_mock_conn()
+    with patch("app.main.get_db", mock_get_db(conn_post)):
+        client.post("/events", json={"code": "abc123"})
+    assert cur.execute.call_args[0][1] == ("", "abc123")
+
+    conn_get, cur = make_mock_conn(fetchone_return={"count": 2, "previews": 0})
+    with patch("app.main.get_db", mock_get_db(conn_get)):
+        r = client.get("/stats/abc123?namespace=brand-b")
+    assert r.json()["count"] == 2
+    assert cur.execute.call_args[0][1] == ("brand-b", "abc123")
+
+
+def test_stats_limit():
+    conn_get, cur = make_mock_conn(fetchone_return={"total": 0})
+    with patch("app.main.get_db", mock_get_db(conn_get)):
+        r = client.get("/stats")
+    assert r.status_code == 200
+    assert cur.execute.call_args_list[0][0][1] == (20,)
+
+    conn_get, cur = make_mock_conn(fetchone_return={"total": 0})
+    with patch("app.main.get_db", mock_get_db(conn_get)):
+        r = client.get("/stats?limit=100")
+    assert r.status_code == 200
+    assert cur.execute.call_args_list[0][0][1] == (100,)
+
+    for bad in ("0", "1001"):
+        r = client.get(f"/stats?limit={bad}")
+        assert r.status_code in (400, 422)
+
+
+def test_event_rejects_invalid_tenant():
+    r = client.post("/events", json={"code": "abc123", "tenant": "brand b"})
+    assert r.status_code in (400, 422)
+
+
+def test_event_rejects_unknown_type():
+    r = client.post("/events", json={"code": "abc123", "type": "view"})
+    assert r.status_code in (400, 422)
+
+
 def test_event_requires_code():
     r = client.post("/events", json={})
     assert r.status_code in (400, 422)
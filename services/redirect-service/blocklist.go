@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// What happens to a click on a blocked destination (BLOCKLIST_ACTION).
+const (
+	blockActionBlock = "block" // 451 Unavailable For Legal Reasons
+	blockActionWarn  = "warn"  // a warning page the visitor may continue from
+)
+
+// Kinds of blocklist entries, as reported in logs and metrics.
+const (
+	blockDomain = "domain"
+	blockSuffix = "suffix"
+	blockRegex  = "regex"
+)
+
+// blocklist matches destination hosts against a local file of unsafe
+// sites (BLOCKLIST_FILE). One entry per line; blank lines and lines
+// starting with '#' are ignored:
+//
+//	evil.example        domain: exactly this host
+//	.evil.example       suffix: this domain and every subdomain
+//	/^login-.*\.example$/  regex: RE2 expression matched against the host
+//
+// Hosts are compared lower-cased, in punycode and without a trailing dot.
+// Like fileResolver, a reload is parsed in full before it replaces the
+// current rules, so a bad file never replaces a good one.
+type blocklist struct {
+	path  string
+	rules atomic.Pointer[blockRules]
+}
+
+type blockRules struct {
+	domains  map[string]bool
+	suffixes map[string]bool // without the leading dot
+	regexps  []*regexp.Regexp
+}
+
+// blockMatch describes the entry that matched a destination.
+type blockMatch struct {
+	Kind  string
+	Entry string
+	Host  string
+}
+
+func newBlocklist(path string) (*blocklist, error) {
+	b := &blocklist{path: path}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// load parses the file and atomically swaps it in.
+func (b *blocklist) load() error {
+	rules, err := readBlocklist(b.path)
+	if err != nil {
+		return err
+	}
+	b.rules.Store(rules)
+	blocklistEntries.Set(float64(len(rules.domains) + len(rules.suffixes) + len(rules.regexps)))
+	return nil
+}
+
+func readBlocklist(path string) (*blockRules, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	rules := &blockRules{domains: map[string]bool{}, suffixes: map[string]bool{}}
+	sc := bufio.NewScanner(fh)
+	for line := 1; sc.Scan(); line++ {
+		entry := strings.TrimSpace(sc.Text())
+		switch {
+		case entry == "" || strings.HasPrefix(entry, "#"):
+		case len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+			re, err := regexp.Compile(entry[1 : len(entry)-1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			rules.regexps = append(rules.regexps, re)
+		default:
+			host, suffix := strings.CutPrefix(entry, ".")
+			if strings.ContainsAny(host, "/:[]* \t") {
+				return nil, fmt.Errorf("%s:%d: invalid entry %q", path, line, entry)
+			}
+			// Destinations are normalised to punycode, so entries are too.
+			host, err := normalizeURLHost(host)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			host = strings.TrimSuffix(host, ".")
+			if suffix {
+				rules.suffixes[host] = true
+			} else {
+				rules.domains[host] = true
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Match reports whether dest's host is blocked.
+func (b *blocklist) Match(dest string) (blockMatch, bool) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" {
+		return blockMatch{}, false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	rules := b.rules.Load()
+	if rules.domains[host] {
+		return blockMatch{Kind: blockDomain, Entry: host, Host: host}, true
+	}
+	// Walk up the labels: a.b.evil.example, b.evil.example, evil.example, ...
+	for d := host; d != ""; {
+		if rules.suffixes[d] {
+			return blockMatch{Kind: blockSuffix, Entry: "." + d, Host: host}, true
+		}
+		_, d, _ = strings.Cut(d, ".")
+	}
+	for _, re := range rules.regexps {
+		if re.MatchString(host) {
+			return blockMatch{Kind: blockRegex, Entry: "/" + re.String() + "/", Host: host}, true
+		}
+	}
+	return blockMatch{}, false
+}
+
+// newWatchedBlocklist loads the blocklist and reloads it whenever the file
+// changes, until ctx is done.
+func newWatchedBlocklist(ctx context.Context, cfg Config, logf func(level, msg string, fields map[string]interface{})) (*blocklist, error) {
+	b, err := newBlocklist(cfg.BlocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	go newFileWatcher("blocklist", cfg.BlocklistFile, cfg.BlocklistReloadInterval, b.load, logf).Run(ctx)
+	return b, nil
+}
+
+// serveBlocked answers a click whose destination matched the blocklist,
+// instead of redirecting: 451, or with blockActionWarn a page showing the
+// destination that the visitor may continue from. Blocked clicks send no
+// analytics event.
+func (h *redirectHandler) serveBlocked(w http.ResponseWriter, r *http.Request, tenant, code, dest string, m blockMatch) {
+	action := h.blockAction
+	if action == "" {
+		action = blockActionBlock
+	}
+	blockedRedirectsTotal.WithLabelValues(m.Kind, action).Inc()
+	h.logf("info", "blocked", map[string]interface{}{
+		"tenant":     tenant,
+		"code":       code,
+		"to":         dest,
+		"host":       m.Host,
+		"kind":       m.Kind,
+		"entry":      m.Entry,
+		"action":     action,
+		"request_id": requestIDFromContext(r.Context()),
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	if action != blockActionWarn {
+		http.Error(w, "blocked", http.StatusUnavailableForLegalReasons)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = blockedTmpl.Execute(w, struct{ Destination string }{dest})
+}
+
+var blockedTmpl = template.Must(template.New("blocked").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Warning: unsafe link</title>
+</head>
+<body>
+<main>
+<section role="alert">
+<h1>This link may be unsafe</h1>
+<p>It leads to a site on our list of known phishing and malware destinations:</p>
+<p><code>{{.Destination}}</code></p>
+</section>
+<p><a href="{{.Destination}}" rel="noreferrer noopener">Continue anyway</a></p>
+</main>
+</body>
+</html>
+`))
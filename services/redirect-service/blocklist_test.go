@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBlocklist = `# phishing
+evil.example
+.Bad.Example.
+/^login-[a-z]+\.example\.net$/
+bücher.example
+`
+
+func TestBlocklistMatch(t *testing.T) {
+	b, err := newBlocklist(writeTestFile(t, "blocklist.txt", testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		dest  string
+		kind  string
+		entry string
+	}{
+		{"https://evil.example/x", blockDomain, "evil.example"},
+		{"https://EVIL.example.:8443/", blockDomain, "evil.example"},
+		{"https://bad.example/", blockSuffix, ".bad.example"},
+		{"http://a.b.bad.example/?q=1", blockSuffix, ".bad.example"},
+		{"https://login-bank.example.net/", blockRegex, `/^login-[a-z]+\.example\.net$/`},
+		{"https://xn--bcher-kva.example/", blockDomain, "xn--bcher-kva.example"},
+		{"https://sub.evil.example/", "", ""},
+		{"https://notbad.example/", "", ""},
+		{"https://login-1.example.net/", "", ""},
+		{"https://example.com/evil.example", "", ""},
+		{"not a url", "", ""},
+	}
+	for _, tc := range cases {
+		m, blocked := b.Match(tc.dest)
+		if blocked != (tc.kind != "") || m.Kind != tc.kind || m.Entry != tc.entry {
+			t.Fatalf("%s: expected %q %q, got %+v %v", tc.dest, tc.kind, tc.entry, m, blocked)
+		}
+	}
+}
+
+func TestReadBlocklistErrors(t *testing.T) {
+	for _, content := range []string{
+		"ok.example\n/[/\n",
+		"ok.example\nhttps://evil.example/\n",
+		".\n",
+	} {
+		path := writeTestFile(t, "blocklist.txt", content)
+		if _, err := readBlocklist(path); err == nil || !strings.HasPrefix(err.Error(), path+":") {
+			t.Fatalf("%q: expected an error naming the line, got %v", content, err)
+		}
+	}
+}
+
+func TestBlocklistReload(t *testing.T) {
+	path := writeTestFile(t, "blocklist.txt", "evil.example\n")
+	b, err := newBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFileWatcher("blocklist", path, time.Hour, b.load, func(string, string, map[string]interface{}) {})
+
+	rewrite := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite("evil.example\nworse.example\n", time.Now().Add(time.Minute))
+	w.poll()
+	if _, blocked := b.Match("https://worse.example/"); !blocked {
+		t.Fatal("expected reloaded entries")
+	}
+
+	// A broken file keeps the previous list in force.
+	rewrite("/(/\n", time.Now().Add(2*time.Minute))
+	w.poll()
+	if _, blocked := b.Match("https://worse.example/"); !blocked {
+		t.Fatal("expected previous list after a bad reload")
+	}
+}
+
+func TestRedirectHandlerBlocklist(t *testing.T) {
+	b, err := newBlocklist(writeTestFile(t, "blocklist.txt", testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, sink := newTestRedirectHandler(staticResolver(map[string]string{
+		"bad": "https://www.bad.example/login",
+		"ok":  "https://example.com/",
+	}))
+	h.blocklist = b
+
+	get := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+
+	rr := get(http.MethodGet, "/r/bad")
+	if rr.Code != http.StatusUnavailableForLegalReasons || rr.Header().Get("Location") != "" {
+		t.Fatalf("expected 451, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected no-store, got %q", rr.Header().Get("Cache-Control"))
+	}
+	if rr := get(http.MethodGet, "/r/ok"); rr.Code != http.StatusFound {
+		t.Fatalf("expected unblocked link to redirect, got %d", rr.Code)
+	}
+
+	h.blockAction = blockActionWarn
+	rr = get(http.MethodGet, "/r/bad")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || rr.Header().Get("Location") != "" || !strings.Contains(body, `href="https://www.bad.example/login"`) {
+		t.Fatalf("expected a warning page, got %d %s", rr.Code, body)
+	}
+	if rr := get(http.MethodHead, "/r/bad"); rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Fatalf("expected an empty HEAD response, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Only the unblocked click is counted.
+	select {
+	case evt := <-sink.ch:
+		if evt.Code != "ok" {
+			t.Fatalf("expected only the unblocked click, got %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected analytics event")
+	}
+	select {
+	case evt := <-sink.ch:
+		t.Fatalf("unexpected event for a blocked click: %+v", evt)
+	default:
+	}
+}
+
+func TestPreviewBlocklisted(t *testing.T) {
+	b, err := newBlocklist(writeTestFile(t, "blocklist.txt", testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newTestRedirectHandler(staticResolver(map[string]string{"bad": "https://evil.example/"}))
+	h.blocklist = b
+
+	req := httptest.NewRequest(http.MethodGet, "/r/bad+", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	var p linkPreview
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Verdict == nil || p.Verdict.Level != verdictBlocked || p.Verdict.Reasons[len(p.Verdict.Reasons)-1] != "blocklisted" {
+		t.Fatalf("expected a blocked verdict, got %+v", p.Verdict)
+	}
+}
+
+func TestRedirectHandlerBlocklistKeepsUses(t *testing.T) {
+	b, err := newBlocklist(writeTestFile(t, "blocklist.txt", testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]resolveResp{
+		"bad":      {LongURL: "https://evil.example/", MaxUses: 1},
+		"fallback": {LongURL: "https://example.com/", MaxUses: 1, FallbackURL: "https://evil.example/over"},
+	}
+	h, _ := newTestRedirectHandler(resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr := links[code]
+		rr.Code = code
+		return rr, nil
+	}))
+	h.blocklist = b
+	h.uses = &memoryUsageCounter{used: map[string]int{}}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+
+	if rr := get("/r/bad"); rr.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected 451, got %d", rr.Code)
+	}
+	if n, _ := h.uses.Remaining(context.Background(), "", "bad", 1); n != 1 {
+		t.Fatalf("expected a blocked click not to use up the link, got %d left", n)
+	}
+
+	// Once used up, the fallback is checked too.
+	if rr := get("/r/fallback"); rr.Code != http.StatusFound {
+		t.Fatalf("expected the first click to redirect, got %d", rr.Code)
+	}
+	if rr := get("/r/fallback"); rr.Code != http.StatusUnavailableForLegalReasons || rr.Header().Get("Location") != "" {
+		t.Fatalf("expected the blocked fallback to get 451, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned without contacting the upstream while the
+// breaker is open (or half-open with all probe slots taken).
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half_open"
+	case breakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker is a consecutive-failure breaker:
+//
+//   - closed: requests flow; failureThreshold consecutive failures open it.
+//   - open: requests fail fast with errCircuitOpen until openTimeout passes.
+//   - half-open: up to halfOpenMax probe requests are let through. If they
+//     all succeed the breaker closes; any failure re-opens it.
+type circuitBreaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenMax      int
+	now              func() time.Time
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+func newCircuitBreaker(failureThreshold int, openTimeout time.Duration, halfOpenMax int) *circuitBreaker {
+	b := &circuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		halfOpenMax:      halfOpenMax,
+		now:              time.Now,
+	}
+	urlServiceBreakerState.Set(float64(breakerClosed))
+	return b
+}
+
+// Allow reports whether a request may proceed. Every nil return must be
+// followed by exactly one call to Record.
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return errCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+	}
+	if b.state == breakerHalfOpen {
+		if b.probes >= b.halfOpenMax {
+			return errCircuitOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Record reports the outcome of a request admitted by Allow.
+func (b *circuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.setState(breakerOpen)
+		}
+	case breakerHalfOpen:
+		if !success {
+			b.setState(breakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenMax {
+			b.setState(breakerClosed)
+		}
+	case breakerOpen:
+		// A request admitted before the breaker opened; its outcome no
+		// longer matters.
+	}
+}
+
+// State returns the current state, moving open to half-open if the
+// cool-down has elapsed so callers such as /ready see an accurate value.
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		b.setState(breakerHalfOpen)
+	}
+	return b.state
+}
+
+func (b *circuitBreaker) setState(s breakerState) {
+	b.state = s
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	if s == breakerOpen {
+		b.openedAt = b.now()
+	}
+	urlServiceBreakerState.Set(float64(s))
+}
+
+// breakerTransport guards an http.RoundTripper with a circuitBreaker.
+// Transport errors and 5xx responses count as failures; anything else,
+// including 404, counts as success.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	t.breaker.Record(err == nil && resp.StatusCode < 500)
+	return resp, err
+}
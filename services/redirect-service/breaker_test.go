@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(3, 10*time.Second, 1)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected closed breaker to allow, got %v", err)
+		}
+		b.Record(false)
+	}
+	if b.State() != breakerOpen {
+		t.Fatalf("expected open after 3 failures, got %s", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected errCircuitOpen, got %v", err)
+	}
+
+	// After the cool-down a single probe is allowed through.
+	now = now.Add(10 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected half-open probe to be allowed, got %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected second probe to be rejected, got %v", err)
+	}
+	b.Record(false)
+	if b.State() != breakerOpen {
+		t.Fatalf("expected failed probe to re-open, got %s", b.State())
+	}
+
+	now = now.Add(10 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected probe after second cool-down, got %v", err)
+	}
+	b.Record(true)
+	if b.State() != breakerClosed {
+		t.Fatalf("expected successful probe to close, got %s", b.State())
+	}
+}
+
+func TestBreakerTransportFailsFast(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	b := newCircuitBreaker(2, time.Minute, 1)
+	c := &http.Client{
+		Timeout:   2 * time.Second,
+		Transport: &breakerTransport{next: http.DefaultTransport, breaker: b},
+	}
+
+	r := newHTTPResolver(c, ts.URL, "")
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(context.Background(), "", "abc"); upstreamStatus(err) != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 error, got %v", err)
+		}
+	}
+	if _, err := r.Resolve(context.Background(), "", "abc"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected errCircuitOpen, got %v", err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("expected upstream to see 2 requests, got %d", n)
+	}
+}
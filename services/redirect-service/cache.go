@@ -0,0 +1,341 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// lruCache is a size-bounded, TTL-bounded LRU map safe for concurrent use.
+// Expired entries are dropped lazily on lookup; when the cache is full the
+// least recently used entry is evicted to make room.
+//
+// With maxStale > 0 an entry outlives its TTL by up to maxStale. Get treats
+// such entries as misses, while GetStale returns them flagged as stale so
+// the caller can serve them while revalidating.
+//
+// The name is used as the "cache" label on the resolve_cache_* metrics so
+// several caches can be sized independently on the same dashboard.
+type lruCache[V any] struct {
+	name     string
+	maxSize  int
+	ttl      time.Duration
+	maxStale time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry[V any] struct {
+	key        string
+	value      V
+	freshUntil time.Time
+	expiresAt  time.Time
+}
+
+func newLRUCache[V any](name string, maxSize int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		name:    name,
+		maxSize: maxSize,
+		ttl:     ttl,
+		now:     time.Now,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element, maxSize),
+	}
+}
+
+// Get returns the cached value for key if present and within its TTL.
+func (c *lruCache[V]) Get(key string) (V, bool) {
+	v, stale, ok := c.lookup(key, false)
+	return v, ok && !stale
+}
+
+// GetStale returns the cached value for key if present and not past its
+// maximum staleness. stale reports whether the entry is past its TTL.
+func (c *lruCache[V]) GetStale(key string) (v V, stale bool, ok bool) {
+	return c.lookup(key, true)
+}
+
+func (c *lruCache[V]) lookup(key string, allowStale bool) (V, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		resolveCacheMissesTotal.WithLabelValues(c.name).Inc()
+		return zero, false, false
+	}
+	ent := el.Value.(*cacheEntry[V])
+	now := c.now()
+	if !now.Before(ent.expiresAt) {
+		c.removeElement(el)
+		resolveCacheMissesTotal.WithLabelValues(c.name).Inc()
+		return zero, false, false
+	}
+	stale := !now.Before(ent.freshUntil)
+	if stale && !allowStale {
+		resolveCacheMissesTotal.WithLabelValues(c.name).Inc()
+		return zero, true, false
+	}
+	c.ll.MoveToFront(el)
+	resolveCacheHitsTotal.WithLabelValues(c.name).Inc()
+	return ent.value, stale, true
+}
+
+// Add inserts or refreshes key, evicting the least recently used entry if
+// the cache is at capacity.
+func (c *lruCache[V]) Add(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	freshUntil := c.now().Add(c.ttl)
+	expiresAt := freshUntil.Add(c.maxStale)
+	if el, ok := c.items[key]; ok {
+		ent := el.Value.(*cacheEntry[V])
+		ent.value = value
+		ent.freshUntil = freshUntil
+		ent.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	for c.ll.Len() >= c.maxSize {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.removeElement(oldest)
+		resolveCacheEvictionsTotal.WithLabelValues(c.name).Inc()
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry[V]{key: key, value: value, freshUntil: freshUntil, expiresAt: expiresAt})
+	resolveCacheEntries.WithLabelValues(c.name).Set(float64(c.ll.Len()))
+}
+
+// Remove drops key from the cache if present.
+func (c *lruCache[V]) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Purge drops every entry.
+func (c *lruCache[V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element, c.maxSize)
+	resolveCacheEntries.WithLabelValues(c.name).Set(0)
+}
+
+// Len returns the number of entries currently held, including expired
+// entries that have not been looked up since they expired.
+func (c *lruCache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *lruCache[V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry[V]).key)
+	resolveCacheEntries.WithLabelValues(c.name).Set(float64(c.ll.Len()))
+}
+
+// cachingResolver is a Resolver that serves lookups from in-process caches
+// and falls through to the wrapped backend on a miss. Successful
+// resolutions go to the positive cache; errNotFound goes to a separate,
+// short-lived negative cache so that scanners walking random codes cannot
+// push real entries out. Other errors are never cached.
+//
+// Entries are keyed by tenant and code (see linkKey). Concurrent misses for
+// the same code are coalesced: only one backend lookup per code is in
+// flight and every waiting caller shares its result, error, or errNotFound.
+//
+// Positive entries past their TTL but within the configured maximum
+// staleness are served immediately (with Stale set) and refreshed in the
+// background. If the refresh fails the stale entry keeps being served until
+// it ages out, so a backend blip doesn't turn recently resolved codes into
+// 502s.
+type cachingResolver struct {
+	cache    *lruCache[resolveResp]
+	negative *lruCache[struct{}]
+	next     Resolver
+	logf     func(level, msg string, fields map[string]interface{})
+	flight   singleflight.Group
+	// namespaces are the tenants a code can be cached under; Invalidate
+	// evicts a code from all of them.
+	namespaces []string
+
+	mu         sync.Mutex
+	refreshing map[string]struct{}
+
+	// generation is bumped by every invalidation. A lookup that started
+	// before an invalidation doesn't store its (possibly outdated) result.
+	// storeMu makes the check and the store atomic with respect to
+	// invalidations, so one can't slip in between them.
+	storeMu    sync.Mutex
+	generation uint64
+	// beforeStore, if set, runs between the generation check and the
+	// store. Tests use it to race invalidations against lookups.
+	beforeStore func()
+}
+
+func newCachingResolver(cfg Config, next Resolver, logf func(level, msg string, fields map[string]interface{})) *cachingResolver {
+	r := &cachingResolver{
+		next:       next,
+		logf:       logf,
+		refreshing: make(map[string]struct{}),
+		namespaces: cfg.Tenants.namespaces(),
+	}
+	if cfg.ResolveCacheSize > 0 {
+		r.cache = newLRUCache[resolveResp]("positive", cfg.ResolveCacheSize, cfg.ResolveCacheTTL)
+		r.cache.maxStale = cfg.ResolveCacheMaxStale
+	}
+	if cfg.NegativeCacheSize > 0 {
+		r.negative = newLRUCache[struct{}]("negative", cfg.NegativeCacheSize, cfg.NegativeCacheTTL)
+	}
+	return r
+}
+
+func (r *cachingResolver) Resolve(ctx context.Context, tenant, code string) (resolveResp, error) {
+	key := linkKey(tenant, code)
+	if r.cache != nil {
+		if rr, stale, ok := r.cache.GetStale(key); ok {
+			if stale {
+				resolveStaleServedTotal.Inc()
+				r.revalidate(ctx, tenant, code)
+				rr.Stale = true
+			}
+			return rr, nil
+		}
+	}
+	if r.negative != nil {
+		if _, ok := r.negative.Get(key); ok {
+			return resolveResp{}, errNotFound
+		}
+	}
+	return r.fetchShared(ctx, tenant, code)
+}
+
+// fetchShared calls the backend through the singleflight group and stores
+// the outcome in the caches.
+func (r *cachingResolver) fetchShared(ctx context.Context, tenant, code string) (resolveResp, error) {
+	// fn only runs in the caller that starts the flight, so leader stays
+	// false for every request that piggybacked on someone else's lookup.
+	// The leader's context (and request ID) is the one passed to the
+	// backend; cancellation is stripped so one client hanging up doesn't
+	// fail everyone waiting on the same code.
+	leader := false
+	key := linkKey(tenant, code)
+	v, err, _ := r.flight.Do(key, func() (interface{}, error) {
+		leader = true
+		r.storeMu.Lock()
+		gen := r.generation
+		r.storeMu.Unlock()
+		rr, err := r.next.Resolve(context.WithoutCancel(ctx), tenant, code)
+		r.storeMu.Lock()
+		if r.generation == gen {
+			if r.beforeStore != nil {
+				r.beforeStore()
+			}
+			r.store(key, rr, err)
+		}
+		r.storeMu.Unlock()
+		return rr, err
+	})
+	if !leader {
+		resolveCoalescedTotal.Inc()
+	}
+	return v.(resolveResp), err
+}
+
+// revalidate refreshes a stale entry in the background. At most one refresh
+// per code runs at a time; further stale hits while it is running are
+// served without starting another.
+func (r *cachingResolver) revalidate(ctx context.Context, tenant, code string) {
+	key := linkKey(tenant, code)
+	r.mu.Lock()
+	if _, busy := r.refreshing[key]; busy {
+		r.mu.Unlock()
+		return
+	}
+	r.refreshing[key] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.refreshing, key)
+			r.mu.Unlock()
+		}()
+		if _, err := r.fetchShared(ctx, tenant, code); err != nil && !errors.Is(err, errNotFound) && r.logf != nil {
+			r.logf("error", "background revalidation failed (serving stale)", map[string]interface{}{
+				"tenant":     tenant,
+				"code":       code,
+				"status":     upstreamStatus(err),
+				"err":        err.Error(),
+				"request_id": requestIDFromContext(ctx),
+			})
+		}
+	}()
+}
+
+func (r *cachingResolver) store(key string, rr resolveResp, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		// The code is gone; don't keep serving a stale mapping for it.
+		if r.cache != nil {
+			r.cache.Remove(key)
+		}
+		if r.negative != nil {
+			r.negative.Add(key, struct{}{})
+		}
+	case err != nil:
+	case r.cache != nil:
+		r.cache.Add(key, rr)
+	}
+}
+
+// Invalidate drops codes from both caches so the next lookup goes to the
+// backend. In-flight lookups for them are detached so later callers don't
+// join a request that started before the change. Invalidation messages
+// carry only the code, so it is dropped in every namespace.
+func (r *cachingResolver) Invalidate(codes ...string) {
+	r.storeMu.Lock()
+	defer r.storeMu.Unlock()
+	r.generation++
+	for _, code := range codes {
+		for _, tenant := range r.namespaces {
+			key := linkKey(tenant, code)
+			r.flight.Forget(key)
+			if r.cache != nil {
+				r.cache.Remove(key)
+			}
+			if r.negative != nil {
+				r.negative.Remove(key)
+			}
+		}
+	}
+}
+
+// InvalidateAll empties both caches.
+func (r *cachingResolver) InvalidateAll() {
+	r.storeMu.Lock()
+	defer r.storeMu.Unlock()
+	r.generation++
+	if r.cache != nil {
+		r.cache.Purge()
+	}
+	if r.negative != nil {
+		r.negative.Purge()
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache[string]("test", 2, time.Minute)
+	c.Add("a", "https://a.example")
+	c.Add("b", "https://b.example")
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected hit for a")
+	}
+	c.Add("c", "https://c.example")
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to survive eviction")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestLRUCacheExpiresEntries(t *testing.T) {
+	now := time.Now()
+	c := newLRUCache[string]("test", 10, time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", "https://a.example")
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected hit before ttl")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss after ttl")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expected expired entry to be dropped, got %d entries", c.Len())
+	}
+}
+
+// resolverFunc adapts a function to the Resolver interface.
+type resolverFunc func(ctx context.Context, tenant, code string) (resolveResp, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, tenant, code string) (resolveResp, error) {
+	return f(ctx, tenant, code)
+}
+
+func TestCachingResolverCachesOnlySuccess(t *testing.T) {
+	calls := map[string]int{}
+	backend := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		calls[code]++
+		if code == "missing" {
+			return resolveResp{}, errNotFound
+		}
+		return resolveResp{Code: code, LongURL: "https://example.com/" + code}, nil
+	})
+	r := newCachingResolver(Config{ResolveCacheSize: 10, ResolveCacheTTL: time.Minute}, backend, nil)
+
+	for i := 0; i < 3; i++ {
+		rr, err := r.Resolve(context.Background(), "", "abc")
+		if err != nil || rr.LongURL != "https://example.com/abc" {
+			t.Fatalf("unexpected result: %+v %v", rr, err)
+		}
+		if _, err := r.Resolve(context.Background(), "", "missing"); !errors.Is(err, errNotFound) {
+			t.Fatalf("expected errNotFound, got %v", err)
+		}
+	}
+
+	if calls["abc"] != 1 {
+		t.Fatalf("expected 1 upstream call for abc, got %d", calls["abc"])
+	}
+	if calls["missing"] != 3 {
+		t.Fatalf("expected 404s to bypass the cache, got %d calls", calls["missing"])
+	}
+}
+
+func TestCachingResolverNegativeCache(t *testing.T) {
+	calls := 0
+	backend := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		calls++
+		if code == "hot" {
+			return resolveResp{Code: code, LongURL: "https://example.com/hot"}, nil
+		}
+		return resolveResp{}, errNotFound
+	})
+	r := newCachingResolver(Config{
+		ResolveCacheSize:  1,
+		ResolveCacheTTL:   time.Minute,
+		NegativeCacheSize: 2,
+		NegativeCacheTTL:  time.Minute,
+	}, backend, nil)
+
+	if _, err := r.Resolve(context.Background(), "", "hot"); err != nil {
+		t.Fatalf("expected hit, got %v", err)
+	}
+	// A flood of unknown codes must only churn the negative cache.
+	for _, code := range []string{"x1", "x2", "x3", "x4"} {
+		if _, err := r.Resolve(context.Background(), "", code); !errors.Is(err, errNotFound) {
+			t.Fatalf("expected errNotFound for %s, got %v", code, err)
+		}
+	}
+	calls = 0
+
+	if _, err := r.Resolve(context.Background(), "", "hot"); err != nil {
+		t.Fatalf("expected hit, got %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), "", "x4"); !errors.Is(err, errNotFound) {
+		t.Fatalf("expected cached errNotFound, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected both lookups to be served from cache, got %d upstream calls", calls)
+	}
+}
+
+func TestCachingResolverCoalescesConcurrentMisses(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := resolverFunc(func(context.Context, string, string) (resolveResp, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return resolveResp{}, &upstreamError{Status: http.StatusBadGateway, Msg: "boom"}
+	})
+	// Caching disabled so every caller that misses the flight would fetch.
+	r := newCachingResolver(Config{}, backend, nil)
+
+	const callers = 10
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := r.Resolve(context.Background(), "", "viral")
+		errs <- err
+	}()
+	<-started
+
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Resolve(context.Background(), "", "viral")
+			errs <- err
+		}()
+	}
+	// Give followers time to join the in-flight request before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", n)
+	}
+	for err := range errs {
+		if upstreamStatus(err) != http.StatusBadGateway {
+			t.Fatalf("expected every caller to share the upstream error, got %v", err)
+		}
+	}
+}
+
+func TestCachingResolverServesStaleOnUpstreamError(t *testing.T) {
+	now := time.Now()
+	var calls atomic.Int32
+	refreshed := make(chan struct{}, 1)
+	backend := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		if calls.Add(1) == 1 {
+			return resolveResp{Code: code, LongURL: "https://example.com/" + code}, nil
+		}
+		defer func() { refreshed <- struct{}{} }()
+		return resolveResp{}, errors.New("connection refused")
+	})
+	r := newCachingResolver(Config{
+		ResolveCacheSize:     10,
+		ResolveCacheTTL:      time.Second,
+		ResolveCacheMaxStale: time.Minute,
+	}, backend, nil)
+	r.cache.now = func() time.Time { return now }
+
+	if rr, err := r.Resolve(context.Background(), "", "abc"); err != nil || rr.Stale {
+		t.Fatalf("expected fresh resolution, got stale=%v err=%v", rr.Stale, err)
+	}
+
+	now = now.Add(2 * time.Second)
+	rr, err := r.Resolve(context.Background(), "", "abc")
+	if err != nil || rr.LongURL != "https://example.com/abc" || !rr.Stale {
+		t.Fatalf("expected stale hit, got %+v err=%v", rr, err)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("expected a background refresh")
+	}
+
+	// Past the maximum staleness the entry is gone and the error surfaces.
+	now = now.Add(2 * time.Minute)
+	if _, err := r.Resolve(context.Background(), "", "abc"); err == nil {
+		t.Fatal("expected upstream error once max staleness is exceeded")
+	}
+}
+
+func TestCachingResolverInvalidateDuringStore(t *testing.T) {
+	backend := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		return resolveResp{Code: code, LongURL: "https://example.com/old"}, nil
+	})
+	r := newCachingResolver(Config{ResolveCacheSize: 10, ResolveCacheTTL: time.Minute}, backend, nil)
+
+	// Invalidate right after the lookup has checked the generation. It
+	// must not complete before the outdated result is stored, or the store
+	// would undo it.
+	invalidated := make(chan struct{})
+	r.beforeStore = func() {
+		r.beforeStore = nil
+		go func() {
+			r.Invalidate("abc")
+			close(invalidated)
+		}()
+		select {
+		case <-invalidated:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if _, err := r.Resolve(context.Background(), "", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	<-invalidated
+	if _, ok := r.cache.Get(linkKey("", "abc")); ok {
+		t.Fatal("expected the invalidation to win over the racing lookup")
+	}
+}
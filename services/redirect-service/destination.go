@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// errInternalDestination is returned by destinationGuard for links that
+// lead to an internal address (see internalHost).
+var errInternalDestination = errors.New("destination is an internal address")
+
+// hostProfile converts hosts to their ASCII (punycode) form the way a
+// browser looks them up. Underscores, which STD3 rules forbid but real
+// hostnames use, are allowed; everything else is checked by isASCIIHost.
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// normalizeDestination checks that s is an absolute http(s) URL that a
+// browser will follow to an unambiguous host, and returns it in canonical
+// form: scheme and host lower-cased, internationalised domains in punycode
+// ("https://bücher.example/" becomes "https://xn--bcher-kva.example/").
+// It rejects URLs without a host, with credentials ("https://bank@evil/")
+// or control characters, and hosts browsers would read as an IPv4 address
+// in another notation ("http://2130706433/"). Whether an IP address host
+// is allowed is up to destinationGuard.
+func normalizeDestination(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !utf8.ValidString(s) {
+		return "", errors.New("invalid UTF-8 in URL")
+	}
+	if strings.ContainsFunc(s, func(c rune) bool { return c < 0x20 || c == 0x7f }) {
+		return "", errors.New("control character in URL")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("not an http(s) URL")
+	}
+	if u.Host == "" || u.Opaque != "" {
+		return "", errors.New("missing host")
+	}
+	if u.User != nil {
+		return "", errors.New("credentials in URL")
+	}
+	host, err := normalizeURLHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	switch port := u.Port(); {
+	case port != "":
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || port[0] == '0' {
+			return "", fmt.Errorf("invalid port %q", port)
+		}
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	return u.String(), nil
+}
+
+// normalizeURLHost returns the canonical form of a URL's host: an IP
+// address as netip formats it, or a domain name in lower-case ASCII.
+func normalizeURLHost(host string) (string, error) {
+	if host == "" {
+		return "", errors.New("missing host")
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Zone() != "" {
+			return "", errors.New("IPv6 zone in host")
+		}
+		return ip.String(), nil
+	}
+	if strings.Contains(host, ":") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	ascii, err := hostProfile.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid host %q: %w", host, err)
+	}
+	// The punycode must decode again, or the result wouldn't be stable.
+	if again, err := hostProfile.ToASCII(ascii); err != nil || again != ascii || !isASCIIHost(ascii) {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	// Browsers parse a host whose last label is a number as an IPv4
+	// address, including forms like 127.1 and 0x7f000001.
+	labels := strings.Split(strings.TrimSuffix(ascii, "."), ".")
+	if last := labels[len(labels)-1]; isNumericLabel(last) {
+		return "", fmt.Errorf("ambiguous numeric host %q", host)
+	}
+	return ascii, nil
+}
+
+// isASCIIHost reports whether host is a domain name of non-empty labels
+// made of lower-case letters, digits, '-' and '_', with an optional
+// trailing dot.
+func isASCIIHost(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isNumericLabel reports whether a label is a decimal, octal or hex
+// number as the WHATWG URL parser reads IPv4 parts.
+func isNumericLabel(label string) bool {
+	if h, ok := strings.CutPrefix(label, "0x"); ok {
+		return strings.IndexFunc(h, func(c rune) bool {
+			return !('0' <= c && c <= '9' || 'a' <= c && c <= 'f')
+		}) < 0
+	}
+	return label != "" && strings.IndexFunc(label, func(c rune) bool { return c < '0' || c > '9' }) < 0
+}
+
+// destinationAllowlist lists internal hosts links may still lead to
+// (DESTINATION_ALLOWLIST). A nil list allows none.
+type destinationAllowlist struct {
+	prefixes []netip.Prefix
+	hosts    map[string]bool
+}
+
+// parseDestinationAllowlist parses DESTINATION_ALLOWLIST: comma-separated
+// IP addresses, CIDR prefixes and host names, e.g.
+// "10.20.0.0/16,192.168.1.10,intranet.localhost".
+func parseDestinationAllowlist(s string) (*destinationAllowlist, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	a := &destinationAllowlist{hosts: map[string]bool{}}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if p, err := netip.ParsePrefix(entry); err == nil {
+			a.prefixes = append(a.prefixes, p.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(entry); err == nil && ip.Zone() == "" {
+			a.prefixes = append(a.prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		host, err := normalizeURLHost(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		a.hosts[strings.TrimSuffix(host, ".")] = true
+	}
+	return a, nil
+}
+
+// internalPrefixes are ranges that can reach internal hosts although
+// netip doesn't call them private: shared carrier-grade NAT space, and IPv6
+// prefixes that embed an IPv4 address a gateway may route to.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT (RFC 6598)
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64 (RFC 6052)
+	netip.MustParsePrefix("2002::/16"),     // 6to4 (RFC 3056)
+	netip.MustParsePrefix("::/96"),         // IPv4-compatible, ::a.b.c.d
+}
+
+// internalHost reports whether a normalised host is a private, loopback,
+// link-local, unspecified or one of the internalPrefixes addresses, or a
+// localhost name, and not on the allowlist.
+func (a *destinationAllowlist) internalHost(host string) bool {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsUnspecified() &&
+			!slices.ContainsFunc(internalPrefixes, func(p netip.Prefix) bool { return p.Contains(ip) }) {
+			return false
+		}
+		if a != nil {
+			for _, p := range a.prefixes {
+				if p.Contains(ip) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	name := strings.TrimSuffix(host, ".")
+	if name != "localhost" && !strings.HasSuffix(name, ".localhost") {
+		return false
+	}
+	return a == nil || !a.hosts[name]
+}
+
+// destinationGuard rejects records with a destination on an internal
+// address (see destinationAllowlist), whichever backend they came from.
+// It wraps the cache rather than the backend so that such a link, which
+// fails on every click, is still answered from the cache.
+type destinationGuard struct {
+	next  Resolver
+	allow *destinationAllowlist
+}
+
+func (g *destinationGuard) Resolve(ctx context.Context, tenant, code string) (resolveResp, error) {
+	rr, err := g.next.Resolve(ctx, tenant, code)
+	if err != nil {
+		return rr, err
+	}
+	urls := []string{rr.LongURL, rr.FallbackURL}
+	for _, t := range rr.Rules {
+		urls = append(urls, t.URL)
+	}
+	for _, d := range rr.Destinations {
+		urls = append(urls, d.URL)
+	}
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil || s == "" {
+			continue
+		}
+		if g.allow.internalHost(u.Hostname()) {
+			return resolveResp{}, fmt.Errorf("%w: %s", errInternalDestination, u.Host)
+		}
+	}
+	return rr, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIP maps client IPs to ISO 3166-1 alpha-2 country codes using a local
+// MaxMind DB file (GeoLite2/GeoIP2 Country or City, or any database with the
+// same country record layout). No network access is needed.
+//
+// The file is read fully into memory rather than mmapped, so a reload can
+// swap readers while lookups on the old one are still running.
+type geoIP struct {
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+}
+
+// geoRecord is the part of a MaxMind country record we use. Addresses
+// without a country (e.g. some anycast ranges) fall back to the registered
+// country.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+func newGeoIP(path string) (*geoIP, error) {
+	g := &geoIP{path: path}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// load reads and parses the database and atomically swaps it in.
+func (g *geoIP) load() error {
+	b, err := os.ReadFile(g.path)
+	if err != nil {
+		return err
+	}
+	r, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return err
+	}
+	if r.Metadata.IPVersion != 4 && r.Metadata.IPVersion != 6 {
+		return errors.New("unsupported MaxMind DB ip_version")
+	}
+	g.reader.Store(r)
+	return nil
+}
+
+// Country returns the upper-case country code for ip, or "" if the address
+// is unparsable or not in the database.
+func (g *geoIP) Country(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	var rec geoRecord
+	if err := g.reader.Load().Lookup(parsed, &rec); err != nil {
+		return ""
+	}
+	if rec.Country.ISOCode != "" {
+		return strings.ToUpper(rec.Country.ISOCode)
+	}
+	return strings.ToUpper(rec.RegisteredCountry.ISOCode)
+}
+
+// newWatchedGeoIP loads the database and reloads it whenever the file
+// changes, until ctx is done.
+func newWatchedGeoIP(ctx context.Context, cfg Config, logf func(level, msg string, fields map[string]interface{})) (*geoIP, error) {
+	g, err := newGeoIP(cfg.GeoIPDatabase)
+	if err != nil {
+		return nil, err
+	}
+	go newFileWatcher("geoip", cfg.GeoIPDatabase, cfg.GeoIPReloadInterval, g.load, logf).Run(ctx)
+	return g, nil
+}
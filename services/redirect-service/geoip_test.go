@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeTestMMDB writes a minimal IPv4 MaxMind DB mapping each CIDR to a
+// country record, in the layout GeoLite2-Country uses. Prefixes must not
+// overlap. The format is described at
+// https://maxmind.github.io/MaxMind-DB/.
+func writeTestMMDB(t *testing.T, countries map[string]string) string {
+	t.Helper()
+
+	root := &mmdbNode{}
+	var data bytes.Buffer
+	offsets := map[string]int{}
+	for cidr, country := range countries {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off, ok := offsets[country]
+		if !ok {
+			off = data.Len()
+			offsets[country] = off
+			mmdbMap(&data, 1)
+			mmdbString(&data, "country")
+			mmdbMap(&data, 1)
+			mmdbString(&data, "iso_code")
+			mmdbString(&data, country)
+		}
+		ones, _ := ipnet.Mask.Size()
+		ip := ipnet.IP.To4()
+		n := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - i%8)) & 1
+			if i == ones-1 {
+				n.data[bit] = off + 1 // 0 means unset
+				break
+			}
+			if n.child[bit] == nil {
+				n.child[bit] = &mmdbNode{}
+			}
+			n = n.child[bit]
+		}
+	}
+
+	// Number nodes breadth-first, then emit 24-bit records.
+	nodes := []*mmdbNode{root}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if c != nil {
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	index := map[*mmdbNode]int{}
+	for i, n := range nodes {
+		index[n] = i
+	}
+	nodeCount := len(nodes)
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			v := nodeCount // empty
+			switch {
+			case n.child[bit] != nil:
+				v = index[n.child[bit]]
+			case n.data[bit] != 0:
+				v = nodeCount + 16 + n.data[bit] - 1
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	buf.Write(make([]byte, 16)) // data section separator
+	buf.Write(data.Bytes())
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	mmdbMap(&buf, 9)
+	mmdbString(&buf, "node_count")
+	mmdbUint(&buf, 6, uint64(nodeCount))
+	mmdbString(&buf, "record_size")
+	mmdbUint(&buf, 5, 24)
+	mmdbString(&buf, "ip_version")
+	mmdbUint(&buf, 5, 4)
+	mmdbString(&buf, "database_type")
+	mmdbString(&buf, "Test-Country")
+	mmdbString(&buf, "languages")
+	buf.Write([]byte{0, 4}) // empty array (extended type 11)
+	mmdbString(&buf, "binary_format_major_version")
+	mmdbUint(&buf, 5, 2)
+	mmdbString(&buf, "binary_format_minor_version")
+	mmdbUint(&buf, 5, 0)
+	mmdbString(&buf, "build_epoch")
+	buf.Write([]byte{8, 2}) // uint64 (extended type 9), 8 bytes
+	_ = binary.Write(&buf, binary.BigEndian, uint64(time.Now().Unix()))
+	mmdbString(&buf, "description")
+	mmdbMap(&buf, 0)
+
+	return writeTestFile(t, "test.mmdb", buf.String())
+}
+
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  [2]int // data section offset + 1 for leaf records
+}
+
+func mmdbString(b *bytes.Buffer, s string) {
+	b.WriteByte(2<<5 | byte(len(s))) // len < 29
+	b.WriteString(s)
+}
+
+func mmdbMap(b *bytes.Buffer, n int) {
+	b.WriteByte(7<<5 | byte(n))
+}
+
+// mmdbUint writes a uint16 (typ 5) or uint32 (typ 6).
+func mmdbUint(b *bytes.Buffer, typ byte, v uint64) {
+	var raw []byte
+	for v > 0 {
+		raw = append([]byte{byte(v)}, raw...)
+		v >>= 8
+	}
+	b.WriteByte(typ<<5 | byte(len(raw)))
+	b.Write(raw)
+}
+
+func TestGeoIPCountry(t *testing.T) {
+	path := writeTestMMDB(t, map[string]string{
+		"192.0.2.0/24":    "DE",
+		"198.51.100.0/25": "fr",
+	})
+	g, err := newGeoIP(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"192.0.2.77":     "DE",
+		"198.51.100.1":   "FR",
+		"198.51.100.200": "",
+		"203.0.113.1":    "",
+		"2001:db8::1":    "", // IPv6 in an IPv4-only database
+		"not-an-ip":      "",
+	}
+	for ip, want := range cases {
+		if got := g.Country(ip); got != want {
+			t.Errorf("Country(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	path := writeTestMMDB(t, map[string]string{"192.0.2.0/24": "DE"})
+	g, err := newGeoIP(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFileWatcher("geoip", path, time.Hour, g.load, func(string, string, map[string]interface{}) {})
+
+	// A broken file keeps the previous database serving.
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.poll()
+	if got := g.Country("192.0.2.1"); got != "DE" {
+		t.Fatalf("expected previous database after a bad reload, got %q", got)
+	}
+
+	next, err := os.ReadFile(writeTestMMDB(t, map[string]string{"192.0.2.0/24": "AT"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, next, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.poll()
+	if got := g.Country("192.0.2.1"); got != "AT" {
+		t.Fatalf("expected reloaded database, got %q", got)
+	}
+}
+
+func TestRedirectHandlerGeoTargeting(t *testing.T) {
+	g, err := newGeoIP(writeTestMMDB(t, map[string]string{"192.0.2.0/24": "DE"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		return resolveResp{
+			Code:    code,
+			LongURL: "https://example.com/",
+			Rules:   []targetRule{{Variant: "dach", URL: "https://example.com/de", Countries: []string{"DE", "AT", "CH"}}},
+		}, nil
+	})
+	h, sink := newTestRedirectHandler(resolver)
+	h.geo = g
+
+	cases := []struct {
+		remoteAddr, xff string
+		trustProxy      bool
+		wantLoc         string
+		wantCountry     string
+	}{
+		{"192.0.2.10:1234", "", false, "https://example.com/de", "DE"},
+		{"203.0.113.1:1234", "", false, "https://example.com/", ""},
+		{"10.0.0.1:1234", "192.0.2.10", true, "https://example.com/de", "DE"},
+		{"10.0.0.1:1234", "192.0.2.10", false, "https://example.com/", ""},
+	}
+	for _, tc := range cases {
+		h.trustProxy = tc.trustProxy
+		req := httptest.NewRequest(http.MethodGet, "/r/campaign", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if loc := rr.Header().Get("Location"); loc != tc.wantLoc {
+			t.Fatalf("%s (xff %q): expected %s, got %s", tc.remoteAddr, tc.xff, tc.wantLoc, loc)
+		}
+		if evt := <-sink.ch; evt.Country != tc.wantCountry {
+			t.Fatalf("%s (xff %q): expected country %q in analytics event, got %q", tc.remoteAddr, tc.xff, tc.wantCountry, evt.Country)
+		}
+	}
+}
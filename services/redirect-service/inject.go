@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Conflict rules for injected parameters the destination already has,
+// per link (inject_conflict) or service-wide (INJECT_CONFLICT).
+const (
+	injectKeep    = "keep"    // the destination's value wins
+	injectReplace = "replace" // the injected value wins
+)
+
+func isInjectConflict(s string) bool {
+	return s == injectKeep || s == injectReplace
+}
+
+// Placeholders an injected parameter value may use.
+const (
+	placeholderCode      = "{code}"
+	placeholderRequestID = "{request_id}"
+	placeholderCountry   = "{country}"
+	placeholderVariant   = "{variant}"
+)
+
+var placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validateParamTemplates checks parameter names and that every
+// placeholder in a value is known.
+func validateParamTemplates(params map[string]string) error {
+	for name, tmpl := range params {
+		if name == "" {
+			return fmt.Errorf("empty parameter name")
+		}
+		for _, p := range placeholderRe.FindAllString(tmpl, -1) {
+			switch p {
+			case placeholderCode, placeholderRequestID, placeholderCountry, placeholderVariant:
+			default:
+				return fmt.Errorf("unknown placeholder %s in parameter %q", p, name)
+			}
+		}
+	}
+	return nil
+}
+
+// parseParamTemplates parses INJECT_PARAMS, which uses query string syntax:
+// "utm_source=short&utm_campaign={code}".
+func parseParamTemplates(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	q, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(q))
+	for name, vs := range q {
+		if len(vs) > 1 {
+			return nil, fmt.Errorf("parameter %q given more than once", name)
+		}
+		params[name] = vs[0]
+	}
+	return params, validateParamTemplates(params)
+}
+
+// clickVars are the values placeholders expand to for one redirect.
+type clickVars struct {
+	Code, RequestID, Country, Variant string
+}
+
+// injectedQuery combines the service-wide and per-link templates, expands
+// them for this click and returns them as an encoded query string, sorted
+// by name. A per-link value replaces the service-wide one; an empty
+// per-link value removes it. Parameters that expand to an empty value,
+// such as "{country}" without GeoIP, are left out.
+func injectedQuery(global, link map[string]string, v clickVars) string {
+	params := make(map[string]string, len(global)+len(link))
+	for name, tmpl := range global {
+		params[name] = tmpl
+	}
+	for name, tmpl := range link {
+		if tmpl == "" {
+			delete(params, name)
+			continue
+		}
+		params[name] = tmpl
+	}
+
+	r := strings.NewReplacer(
+		placeholderCode, v.Code,
+		placeholderRequestID, v.RequestID,
+		placeholderCountry, v.Country,
+		placeholderVariant, v.Variant,
+	)
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []string
+	for _, name := range names {
+		if val := r.Replace(params[name]); val != "" {
+			out = append(out, url.QueryEscape(name)+"="+url.QueryEscape(val))
+		}
+	}
+	return strings.Join(out, "&")
+}
+
+// injectParams adds the encoded query to dest. With injectKeep a parameter
+// dest already has, including one forwarded from the request, is left
+// alone; with injectReplace it is replaced.
+func injectParams(dest, query, conflict string) (string, error) {
+	if query == "" {
+		return dest, nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", err
+	}
+	mode := queryPassMerge
+	if conflict == injectReplace {
+		mode = queryPassOverride
+	}
+	u.RawQuery = mergeQuery(u.RawQuery, query, mode)
+	return u.String(), nil
+}
+
+// usesPlaceholder reports whether any template that applies to the link
+// contains placeholder.
+func usesPlaceholder(global, link map[string]string, placeholder string) bool {
+	for name, tmpl := range global {
+		if _, overridden := link[name]; !overridden && strings.Contains(tmpl, placeholder) {
+			return true
+		}
+	}
+	for _, tmpl := range link {
+		if strings.Contains(tmpl, placeholder) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseParamTemplates(t *testing.T) {
+	params, err := parseParamTemplates("utm_source=short&utm_campaign=%7Bcode%7D&cid={request_id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 3 || params["utm_campaign"] != "{code}" || params["cid"] != "{request_id}" {
+		t.Fatalf("unexpected params %v", params)
+	}
+	for _, bad := range []string{"a={nope}", "a=1&a=2", "=x", "a=%zz"} {
+		if _, err := parseParamTemplates(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestInjectedQuery(t *testing.T) {
+	vars := clickVars{Code: "abc", RequestID: "req 1", Country: "DE", Variant: "b"}
+	cases := []struct {
+		name         string
+		global, link map[string]string
+		vars         clickVars
+		want         string
+	}{
+		{"none", nil, nil, vars, ""},
+		{"sorted and expanded", map[string]string{"utm_source": "short", "utm_campaign": "{code}"}, nil, vars, "utm_campaign=abc&utm_source=short"},
+		{"values escaped", map[string]string{"cid": "{request_id}&{country}"}, nil, vars, "cid=req+1%26DE"},
+		{"link overrides global", map[string]string{"utm_source": "short"}, map[string]string{"utm_source": "mail", "v": "{variant}"}, vars, "utm_source=mail&v=b"},
+		{"empty link value removes global", map[string]string{"utm_source": "short", "utm_medium": "link"}, map[string]string{"utm_source": ""}, vars, "utm_medium=link"},
+		{"empty expansion left out", map[string]string{"geo": "{country}"}, nil, clickVars{Code: "abc"}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := injectedQuery(tc.global, tc.link, tc.vars); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInjectParams(t *testing.T) {
+	cases := []struct {
+		dest, query, conflict, want string
+	}{
+		{"https://example.com/p", "", injectKeep, "https://example.com/p"},
+		{"https://example.com/p", "utm_source=short", injectKeep, "https://example.com/p?utm_source=short"},
+		{"https://example.com/p?utm_source=news#top", "utm_medium=link&utm_source=short", injectKeep, "https://example.com/p?utm_source=news&utm_medium=link#top"},
+		{"https://example.com/p?utm_source=news&x=1", "utm_source=short", injectReplace, "https://example.com/p?x=1&utm_source=short"},
+	}
+	for _, tc := range cases {
+		got, err := injectParams(tc.dest, tc.query, tc.conflict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s + %s (%s): expected %s, got %s", tc.dest, tc.query, tc.conflict, tc.want, got)
+		}
+	}
+}
+
+func TestRedirectHandlerInjectsParams(t *testing.T) {
+	link := resolveResp{LongURL: "https://example.com/?utm_source=news", RedirectStatus: http.StatusMovedPermanently}
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr := link
+		rr.Code = code
+		return rr, nil
+	})
+	h, _ := newTestRedirectHandler(resolver)
+	h.sink = newAnalyticsSink(Config{AnalyticsQueueLen: 64}, h.logf, nil)
+	h.injectParams = map[string]string{"utm_source": "short", "utm_campaign": "{code}"}
+	h.injectConflict = injectKeep
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(RequestIDHeader, "rid-1")
+		rr := httptest.NewRecorder()
+		withRequestID(h).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/r/spring")
+	if loc := rr.Header().Get("Location"); loc != "https://example.com/?utm_source=news&utm_campaign=spring" {
+		t.Fatalf("expected the destination's utm_source to be kept, got %s", loc)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Fatalf("expected per-code parameters to stay cacheable, got %q", cc)
+	}
+
+	// Per-link templates and conflict rule.
+	link.InjectParams = map[string]string{"click_id": "{request_id}"}
+	link.InjectConflict = injectReplace
+	rr = get("/r/spring")
+	if loc := rr.Header().Get("Location"); loc != "https://example.com/?click_id=rid-1&utm_campaign=spring&utm_source=short" {
+		t.Fatalf("unexpected destination %s", loc)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected per-click parameters not to be cached, got %q", cc)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// invalidateAll is the wildcard accepted in place of a code list.
+const invalidateAll = "*"
+
+// maxInvalidateCodes bounds a single invalidation request.
+const maxInvalidateCodes = 1000
+
+type invalidateRequest struct {
+	Codes []string `json:"codes"`
+}
+
+// invalidateHandler serves POST /internal/invalidate. It is only mounted when
+// INVALIDATION_TOKEN is set and requires it as a bearer token.
+type invalidateHandler struct {
+	resolver *cachingResolver
+	token    string
+	logf     func(level, msg string, fields map[string]interface{})
+}
+
+func (h *invalidateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := requestIDFromContext(r.Context())
+
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+		h.logf("error", "invalidation rejected (bad token)", map[string]interface{}{"request_id": rid})
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req invalidateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid_body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Codes) == 0 || len(req.Codes) > maxInvalidateCodes {
+		http.Error(w, "invalid_codes", http.StatusBadRequest)
+		return
+	}
+
+	for _, code := range req.Codes {
+		if code == invalidateAll {
+			h.resolver.InvalidateAll()
+			cacheInvalidationsTotal.WithLabelValues("api").Inc()
+			h.logf("info", "cache invalidated", map[string]interface{}{
+				"source":     "api",
+				"codes":      invalidateAll,
+				"request_id": rid,
+			})
+			writeJSON(w, http.StatusOK, map[string]interface{}{"invalidated": invalidateAll})
+			return
+		}
+	}
+
+	h.resolver.Invalidate(req.Codes...)
+	cacheInvalidationsTotal.WithLabelValues("api").Add(float64(len(req.Codes)))
+	h.logf("info", "cache invalidated", map[string]interface{}{
+		"source":     "api",
+		"codes":      req.Codes,
+		"request_id": rid,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"invalidated": len(req.Codes)})
+}
+
+// invalidationChannel is the Postgres NOTIFY channel written by the urls
+// table trigger (see url-service migration V2). The payload is the code.
+const invalidationChannel = "url_changes"
+
+// listenForInvalidations evicts codes announced on invalidationChannel until
+// ctx is done. LISTEN only works against a primary, so databaseURL is
+// separate from the (usually replica) resolver database. Notifications sent
+// while disconnected are lost, so the caches are flushed after every
+// reconnect.
+func listenForInvalidations(ctx context.Context, databaseURL string, resolver *cachingResolver, logf func(level, msg string, fields map[string]interface{})) {
+	backoff := time.Second
+	for {
+		connected, err := listenOnce(ctx, databaseURL, resolver, logf)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		logf("error", "invalidation listener disconnected", map[string]interface{}{
+			"err":         err.Error(),
+			"retry_in_ms": backoff.Milliseconds(),
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// listenOnce runs a single LISTEN session. connected reports whether the
+// session got as far as subscribing, so the caller can reset its backoff.
+func listenOnce(ctx context.Context, databaseURL string, resolver *cachingResolver, logf func(level, msg string, fields map[string]interface{})) (connected bool, err error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+invalidationChannel); err != nil {
+		return false, err
+	}
+	resolver.InvalidateAll()
+	logf("info", "invalidation listener connected (caches flushed)", map[string]interface{}{
+		"channel":    invalidationChannel,
+		"request_id": uuid.NewString(),
+	})
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		resolver.Invalidate(n.Payload)
+		cacheInvalidationsTotal.WithLabelValues("notify").Inc()
+		// Notifications carry no request ID, so mint one per event to keep
+		// the log schema uniform.
+		logf("info", "cache invalidated", map[string]interface{}{
+			"source":     "notify",
+			"codes":      []string{n.Payload},
+			"request_id": uuid.NewString(),
+		})
+	}
+}
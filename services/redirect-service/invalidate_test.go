@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvalidateHandler(t *testing.T) {
+	calls := 0
+	backend := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		calls++
+		return resolveResp{Code: code, LongURL: "https://example.com/" + code}, nil
+	})
+	resolver := newCachingResolver(Config{ResolveCacheSize: 10, ResolveCacheTTL: time.Minute}, backend, nil)
+	h := &invalidateHandler{resolver: resolver, token: "s3cret", logf: func(string, string, map[string]interface{}) {}}
+
+	post := func(token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/internal/invalidate", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, code := range []string{"a", "b"} {
+		_, _ = resolver.Resolve(context.Background(), "", code)
+	}
+
+	if got := post("", `{"codes":["a"]}`); got != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", got)
+	}
+	if got := post("wrong", `{"codes":["a"]}`); got != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", got)
+	}
+	bare := httptest.NewRequest(http.MethodPost, "/internal/invalidate", strings.NewReader(`{"codes":["a"]}`))
+	bare.Header.Set("Authorization", "s3cret")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, bare)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a token without the Bearer scheme, got %d", rr.Code)
+	}
+	if got := post("s3cret", `{"codes":[]}`); got != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty codes, got %d", got)
+	}
+
+	if got := post("s3cret", `{"codes":["a"]}`); got != http.StatusOK {
+		t.Fatalf("expected 200, got %d", got)
+	}
+	calls = 0
+	_, _ = resolver.Resolve(context.Background(), "", "a")
+	_, _ = resolver.Resolve(context.Background(), "", "b")
+	if calls != 1 {
+		t.Fatalf("expected only the invalidated code to be refetched, got %d calls", calls)
+	}
+
+	if got := post("s3cret", `{"codes":["*"]}`); got != http.StatusOK {
+		t.Fatalf("expected 200 for wildcard, got %d", got)
+	}
+	if resolver.cache.Len() != 0 {
+		t.Fatalf("expected wildcard to empty the cache, got %d entries", resolver.cache.Len())
+	}
+}
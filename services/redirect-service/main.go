@@ -3,10 +3,12 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -14,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,12 +27,61 @@ import (
 )
 
 type Config struct {
-	Host    string
-	Port    int
-	BaseURL string
-	AnalyticsBaseURL  string
-	AnalyticsTimeout  time.Duration
-	AnalyticsQueueLen int
+	Host                    string
+	Port                    int
+	BaseURL                 string
+	AnalyticsBaseURL        string
+	AnalyticsTimeout        time.Duration
+	AnalyticsQueueLen       int
+	ResolveCacheSize        int
+	ResolveCacheTTL         time.Duration
+	ResolveCacheMaxStale    time.Duration
+	NegativeCacheSize       int
+	NegativeCacheTTL        time.Duration
+	URLServiceTimeout       time.Duration
+	URLServiceToken         string
+	BreakerFailures         int
+	BreakerOpenTimeout      time.Duration
+	BreakerHalfOpenMax      int
+	Resolver                string
+	ResolverDatabaseURL     string
+	ResolverDBPoolMax       int
+	ResolverDBPoolMin       int
+	ResolverDBQueryTimeout  time.Duration
+	ResolverFile            string
+	ResolverFallbackFile    string
+	SnapshotReloadInterval  time.Duration
+	InvalidationToken       string
+	InvalidationDBURL       string
+	UsageDatabaseURL        string
+	UsageDBPoolMax          int
+	UsageDBQueryTimeout     time.Duration
+	WarmupSource            string
+	WarmupFile              string
+	WarmupTopN              int
+	WarmupConcurrency       int
+	WarmupTimeout           time.Duration
+	DefaultRedirectStatus   int
+	PermanentRedirectTTL    time.Duration
+	TrustProxy              bool
+	UnlockCookieSecret      []byte
+	UnlockCookieTTL         time.Duration
+	UnlockCookieSecure      bool
+	PasswordMaxFailures     int
+	PasswordFailureWindow   time.Duration
+	GeoIPDatabase           string
+	GeoIPReloadInterval     time.Duration
+	QueryPassthrough        string
+	PathPassthrough         bool
+	InjectParams            map[string]string
+	InjectConflict          string
+	PublicBaseURL           string
+	Tenants                 tenantMap
+	RootPathLinks           bool
+	BlocklistFile           string
+	BlocklistReloadInterval time.Duration
+	BlocklistAction         string
+	DestinationAllowlist    *destinationAllowlist
 }
 
 func loadConfig() (Config, error) {
@@ -44,6 +96,195 @@ func loadConfig() (Config, error) {
 	// Base URL for url-service resolve endpoint.
 	baseURL := getenv("URL_SERVICE_BASE_URL", "http://url-service:3000")
 
+	// Backend used to resolve codes: url-service over HTTP (default), a
+	// read-only Postgres connection to the urls table, or a static file.
+	resolverKind := getenv("RESOLVER", resolverHTTP)
+	switch resolverKind {
+	case resolverHTTP, resolverPostgres, resolverFile:
+	default:
+		return Config{}, errors.New("invalid RESOLVER")
+	}
+
+	// Connection pool for RESOLVER=postgres. Typically points at a read
+	// replica; the query timeout bounds each lookup on the redirect path.
+	dbPoolMax, err := getenvInt("RESOLVER_DB_POOL_MAX", 10, 1, 1000)
+	if err != nil {
+		return Config{}, err
+	}
+	dbPoolMin, err := getenvInt("RESOLVER_DB_POOL_MIN", 2, 0, dbPoolMax)
+	if err != nil {
+		return Config{}, err
+	}
+	dbTimeoutMs, err := getenvInt("RESOLVER_DB_QUERY_TIMEOUT_MS", 500, 1, 30_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Counter store for max_uses links. Must be the primary: every use is a
+	// write.
+	usagePoolMax, err := getenvInt("USAGE_DB_POOL_MAX", 5, 1, 1000)
+	if err != nil {
+		return Config{}, err
+	}
+	usageTimeoutMs, err := getenvInt("USAGE_DB_QUERY_TIMEOUT_MS", 500, 1, 30_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Snapshot files (RESOLVER_FILE, RESOLVER_FALLBACK_FILE) are polled for
+	// changes at this interval. 0 disables hot reload.
+	snapshotReloadMs, err := getenvInt("SNAPSHOT_RELOAD_INTERVAL_MS", 5_000, 0, 3_600_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Optional cache warm-up before /ready reports ready.
+	warmupSource := getenv("WARMUP_SOURCE", "")
+	switch warmupSource {
+	case "", warmupFromFile, warmupFromAnalytics:
+	default:
+		return Config{}, errors.New("invalid WARMUP_SOURCE")
+	}
+	warmupTopN, err := getenvInt("WARMUP_TOP_N", 100, 1, 100_000)
+	if err != nil {
+		return Config{}, err
+	}
+	warmupConcurrency, err := getenvInt("WARMUP_CONCURRENCY", 8, 1, 256)
+	if err != nil {
+		return Config{}, err
+	}
+	warmupTimeoutMs, err := getenvInt("WARMUP_TIMEOUT_MS", 10_000, 1, 600_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Redirect status for links that don't set their own, and how long
+	// clients may cache permanent redirects.
+	defaultStatus, err := getenvInt("DEFAULT_REDIRECT_STATUS", http.StatusFound, 301, 308)
+	if err != nil || !isRedirectStatus(defaultStatus) {
+		return Config{}, errors.New("invalid DEFAULT_REDIRECT_STATUS")
+	}
+	permanentMaxAge, err := getenvInt("PERMANENT_REDIRECT_MAX_AGE_SECONDS", 3600, 0, 31_536_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Client addresses are taken from X-Forwarded-For only behind a trusted
+	// proxy; otherwise any client could pick its own.
+	trustProxy, err := getenvBool("TRUST_PROXY", false)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Password-protected links: unlock cookie lifetime and the per IP+code
+	// limit on failed attempts.
+	unlockTTLMs, err := getenvInt("UNLOCK_COOKIE_TTL_MS", 900_000, 1_000, 86_400_000)
+	if err != nil {
+		return Config{}, err
+	}
+	unlockSecure, err := getenvBool("UNLOCK_COOKIE_SECURE", true)
+	if err != nil {
+		return Config{}, err
+	}
+	pwMaxFailures, err := getenvInt("PASSWORD_MAX_FAILURES", 5, 1, 1000)
+	if err != nil {
+		return Config{}, err
+	}
+	pwWindowMs, err := getenvInt("PASSWORD_FAILURE_WINDOW_MS", 900_000, 1_000, 86_400_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Optional offline GeoIP database, polled for changes at this interval.
+	geoReloadMs, err := getenvInt("GEOIP_RELOAD_INTERVAL_MS", 60_000, 0, 86_400_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Defaults for links that don't set query_passthrough/path_passthrough.
+	queryPassthrough := getenv("QUERY_PASSTHROUGH", queryPassOff)
+	if !isQueryPassthroughMode(queryPassthrough) {
+		return Config{}, errors.New("invalid QUERY_PASSTHROUGH")
+	}
+	pathPassthrough, err := getenvBool("PATH_PASSTHROUGH", false)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Parameter templates added to every destination, and what to do when
+	// the destination already has one.
+	injectParams, err := parseParamTemplates(os.Getenv("INJECT_PARAMS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid INJECT_PARAMS: %w", err)
+	}
+	injectConflict := getenv("INJECT_CONFLICT", injectKeep)
+	if !isInjectConflict(injectConflict) {
+		return Config{}, errors.New("invalid INJECT_CONFLICT")
+	}
+
+	// Public origin of short links, encoded into QR codes. /qr/ is only
+	// served when it is set.
+	publicBaseURL := strings.TrimRight(getenv("PUBLIC_BASE_URL", ""), "/")
+	if publicBaseURL != "" {
+		if u, err := url.Parse(publicBaseURL); err != nil || !isHTTPURL(publicBaseURL) || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return Config{}, errors.New("invalid PUBLIC_BASE_URL")
+		}
+	}
+
+	// Vanity domains: which hosts serve which code namespace. Unset, every
+	// host serves the default namespace.
+	tenants, err := parseTenants(os.Getenv("TENANTS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid TENANTS: %w", err)
+	}
+
+	// Serve /{code} as well as /r/{code}.
+	rootPathLinks, err := getenvBool("ROOT_PATH_LINKS", false)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Optional local blocklist of unsafe destination hosts, polled for
+	// changes at this interval. Matching clicks get a 451 (block) or a
+	// warning page (warn).
+	blocklistReloadMs, err := getenvInt("BLOCKLIST_RELOAD_INTERVAL_MS", 60_000, 0, 86_400_000)
+	if err != nil {
+		return Config{}, err
+	}
+	blocklistAction := getenv("BLOCKLIST_ACTION", blockActionBlock)
+	switch blocklistAction {
+	case blockActionBlock, blockActionWarn:
+	default:
+		return Config{}, errors.New("invalid BLOCKLIST_ACTION")
+	}
+
+	// Private, loopback and link-local destinations are refused unless
+	// listed here.
+	destAllowlist, err := parseDestinationAllowlist(os.Getenv("DESTINATION_ALLOWLIST"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid DESTINATION_ALLOWLIST: %w", err)
+	}
+
+	urlTimeoutMs, err := getenvInt("URL_SERVICE_TIMEOUT_MS", 1500, 1, 30_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Circuit breaker around url-service: open after N consecutive failures,
+	// fail fast for the cool-down, then let a few probes through.
+	breakerFailures, err := getenvInt("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5, 1, 1000)
+	if err != nil {
+		return Config{}, err
+	}
+	breakerOpenMs, err := getenvInt("CIRCUIT_BREAKER_OPEN_MS", 10_000, 1, 600_000)
+	if err != nil {
+		return Config{}, err
+	}
+	breakerProbes, err := getenvInt("CIRCUIT_BREAKER_HALF_OPEN_REQUESTS", 1, 1, 100)
+	if err != nil {
+		return Config{}, err
+	}
+
 	analyticsBase := getenv("ANALYTICS_SERVICE_BASE_URL", "http://analytics-service:8000")
 
 	timeoutMs := getenv("ANALYTICS_TIMEOUT_MS", "300")
@@ -58,13 +299,89 @@ func loadConfig() (Config, error) {
 		return Config{}, errors.New("invalid ANALYTICS_QUEUE_SIZE")
 	}
 
+	// In-process resolve cache in front of url-service. A size of 0 disables it.
+	cacheSize, err := getenvInt("RESOLVE_CACHE_SIZE", 10_000, 0, 10_000_000)
+	if err != nil {
+		return Config{}, err
+	}
+	cacheTTLMs, err := getenvInt("RESOLVE_CACHE_TTL_MS", 60_000, 1, 86_400_000)
+	if err != nil {
+		return Config{}, err
+	}
+	// How long past its TTL a cached resolution may still be served while it
+	// is refreshed in the background. 0 disables stale serving.
+	maxStaleMs, err := getenvInt("RESOLVE_CACHE_MAX_STALE_MS", 300_000, 0, 86_400_000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Negative cache for codes url-service reported as not found. Kept short
+	// so newly created links become reachable quickly.
+	negSize, err := getenvInt("NEGATIVE_CACHE_SIZE", 10_000, 0, 10_000_000)
+	if err != nil {
+		return Config{}, err
+	}
+	negTTLMs, err := getenvInt("NEGATIVE_CACHE_TTL_MS", 5_000, 1, 3_600_000)
+	if err != nil {
+		return Config{}, err
+	}
+
 	return Config{
-		Host:    host,
-		Port:    port,
-		BaseURL:  baseURL,
-		AnalyticsBaseURL:  analyticsBase,
-		AnalyticsTimeout:  time.Duration(tms) * time.Millisecond,
-		AnalyticsQueueLen: ql,
+		Host:                    host,
+		Port:                    port,
+		BaseURL:                 baseURL,
+		AnalyticsBaseURL:        analyticsBase,
+		AnalyticsTimeout:        time.Duration(tms) * time.Millisecond,
+		AnalyticsQueueLen:       ql,
+		ResolveCacheSize:        cacheSize,
+		ResolveCacheTTL:         time.Duration(cacheTTLMs) * time.Millisecond,
+		ResolveCacheMaxStale:    time.Duration(maxStaleMs) * time.Millisecond,
+		NegativeCacheSize:       negSize,
+		NegativeCacheTTL:        time.Duration(negTTLMs) * time.Millisecond,
+		URLServiceTimeout:       time.Duration(urlTimeoutMs) * time.Millisecond,
+		URLServiceToken:         os.Getenv("URL_SERVICE_INTERNAL_TOKEN"),
+		BreakerFailures:         breakerFailures,
+		BreakerOpenTimeout:      time.Duration(breakerOpenMs) * time.Millisecond,
+		BreakerHalfOpenMax:      breakerProbes,
+		Resolver:                resolverKind,
+		ResolverDatabaseURL:     os.Getenv("RESOLVER_DATABASE_URL"),
+		ResolverDBPoolMax:       dbPoolMax,
+		ResolverDBPoolMin:       dbPoolMin,
+		ResolverDBQueryTimeout:  time.Duration(dbTimeoutMs) * time.Millisecond,
+		ResolverFile:            getenv("RESOLVER_FILE", ""),
+		ResolverFallbackFile:    getenv("RESOLVER_FALLBACK_FILE", ""),
+		SnapshotReloadInterval:  time.Duration(snapshotReloadMs) * time.Millisecond,
+		InvalidationToken:       os.Getenv("INVALIDATION_TOKEN"),
+		InvalidationDBURL:       os.Getenv("INVALIDATION_DATABASE_URL"),
+		UsageDatabaseURL:        os.Getenv("USAGE_DATABASE_URL"),
+		UsageDBPoolMax:          usagePoolMax,
+		UsageDBQueryTimeout:     time.Duration(usageTimeoutMs) * time.Millisecond,
+		WarmupSource:            warmupSource,
+		WarmupFile:              getenv("WARMUP_FILE", ""),
+		WarmupTopN:              warmupTopN,
+		WarmupConcurrency:       warmupConcurrency,
+		WarmupTimeout:           time.Duration(warmupTimeoutMs) * time.Millisecond,
+		DefaultRedirectStatus:   defaultStatus,
+		PermanentRedirectTTL:    time.Duration(permanentMaxAge) * time.Second,
+		TrustProxy:              trustProxy,
+		UnlockCookieSecret:      []byte(os.Getenv("UNLOCK_COOKIE_SECRET")),
+		UnlockCookieTTL:         time.Duration(unlockTTLMs) * time.Millisecond,
+		UnlockCookieSecure:      unlockSecure,
+		PasswordMaxFailures:     pwMaxFailures,
+		PasswordFailureWindow:   time.Duration(pwWindowMs) * time.Millisecond,
+		GeoIPDatabase:           os.Getenv("GEOIP_DATABASE"),
+		GeoIPReloadInterval:     time.Duration(geoReloadMs) * time.Millisecond,
+		QueryPassthrough:        queryPassthrough,
+		PathPassthrough:         pathPassthrough,
+		InjectParams:            injectParams,
+		InjectConflict:          injectConflict,
+		PublicBaseURL:           publicBaseURL,
+		Tenants:                 tenants,
+		RootPathLinks:           rootPathLinks,
+		BlocklistFile:           getenv("BLOCKLIST_FILE", ""),
+		BlocklistReloadInterval: time.Duration(blocklistReloadMs) * time.Millisecond,
+		BlocklistAction:         blocklistAction,
+		DestinationAllowlist:    destAllowlist,
 	}, nil
 }
 
@@ -75,57 +392,51 @@ func getenv(key, def string) string {
 	return def
 }
 
-func isHTTPURL(s string) bool {
-	s = strings.ToLower(strings.TrimSpace(s))
-	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
-}
-
-type resolveResp struct {
-	Code    string `json:"code"`
-	LongURL string `json:"long_url"`
+// getenvInt parses an integer environment variable and checks that it lies
+// within [min, max]. The returned error names the offending variable.
+func getenvInt(key string, def, min, max int) (int, error) {
+	v, err := strconv.Atoi(getenv(key, strconv.Itoa(def)))
+	if err != nil || v < min || v > max {
+		return 0, errors.New("invalid " + key)
+	}
+	return v, nil
 }
 
-func resolveLongURL(client *http.Client, base string, code string, requestID string) (string, int, error) {
-	base = strings.TrimRight(base, "/")
-	endpoint := base + "/urls/" + url.PathEscape(code)
-
-	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+// getenvBool parses a boolean environment variable (strconv.ParseBool
+// syntax). The returned error names the offending variable.
+func getenvBool(key string, def bool) (bool, error) {
+	v, err := strconv.ParseBool(getenv(key, strconv.FormatBool(def)))
 	if err != nil {
-		return "", 0, err
+		return false, errors.New("invalid " + key)
 	}
-	req.Header.Set("Accept", "application/json")
+	return v, nil
+}
 
-	// Propagate request id to url-service for cross-service tracing.
-	if requestID != "" {
-		req.Header.Set(RequestIDHeader, requestID)
+// clientIP returns the address of the client that sent r. With trustProxy
+// it is the last X-Forwarded-For entry, the one appended by our own proxy;
+// earlier entries are client-supplied and can't be trusted.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			last := xff[len(xff)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
 	}
-
-	resp, err := client.Do(req)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return "", 0, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return "", http.StatusNotFound, nil
-	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		msg := strings.TrimSpace(string(b))
-		if msg == "" {
-			msg = resp.Status
-		}
-		return "", resp.StatusCode, errors.New("url-service error: " + msg)
+		return r.RemoteAddr
 	}
+	return host
+}
 
-	var rr resolveResp
-	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
-		return "", resp.StatusCode, err
-	}
-	if !isHTTPURL(rr.LongURL) {
-		return "", resp.StatusCode, errors.New("invalid long_url from url-service")
-	}
-	return rr.LongURL, resp.StatusCode, nil
+func isHTTPURL(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
 }
 
 // logf emits a single JSON line to stdout.
@@ -147,12 +458,22 @@ func logger(_ Config) func(level, msg string, fields map[string]interface{}) {
 	}
 }
 
+// Analytics event types. Previews are recorded separately from clicks.
+const (
+	eventClick   = "click"
+	eventPreview = "preview"
+)
+
 type analyticsEvent struct {
 	Code      string `json:"code"`
+	Tenant    string `json:"tenant,omitempty"`
+	Type      string `json:"type,omitempty"`
 	TS        int64  `json:"ts,omitempty"`
 	UserAgent string `json:"user_agent,omitempty"`
 	Referrer  string `json:"referrer,omitempty"`
 	RequestID string `json:"request_id,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	Country   string `json:"country,omitempty"`
 }
 
 type analyticsSink struct {
@@ -237,8 +558,8 @@ func (s *analyticsSink) post(evt analyticsEvent) {
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 		s.logf("error", "analytics non-2xx", map[string]interface{}{
-			"status": resp.StatusCode,
-			"body":   strings.TrimSpace(string(b)),
+			"status":     resp.StatusCode,
+			"body":       strings.TrimSpace(string(b)),
 			"request_id": evt.RequestID,
 		})
 	}
@@ -298,10 +619,61 @@ func main() {
 
 	// Wrap HTTP transport with OTel instrumentation so outbound calls to
 	// url-service and analytics-service automatically inject the traceparent
-	// header and create child spans.
+	// header and create child spans. The circuit breaker sits inside the OTel
+	// transport so fast-failed calls still show up as errored spans.
+	breaker := newCircuitBreaker(cfg.BreakerFailures, cfg.BreakerOpenTimeout, cfg.BreakerHalfOpenMax)
 	resolveClient := &http.Client{
-		Timeout:   1500 * time.Millisecond,
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   cfg.URLServiceTimeout,
+		Transport: otelhttp.NewTransport(&breakerTransport{next: http.DefaultTransport, breaker: breaker}),
+	}
+
+	backend, closeBackend, err := buildResolver(ctx, cfg, resolveClient, logf)
+	if err != nil {
+		logf("error", "resolver init failed", map[string]interface{}{"resolver": cfg.Resolver, "err": err.Error()})
+		os.Exit(1)
+	}
+	defer closeBackend()
+
+	// Cache resolutions (and not-found answers) in-process so hot codes and
+	// scanner probes don't cost a backend lookup on every hit.
+	resolver := newCachingResolver(cfg, backend, logf)
+
+	// Evict codes changed in url-service as soon as Postgres announces them.
+	if cfg.InvalidationDBURL != "" {
+		go listenForInvalidations(ctx, cfg.InvalidationDBURL, resolver, logf)
+	}
+
+	// Strongly consistent use counter for max_uses links. Without it such
+	// links fail closed.
+	var uses usageCounter
+	if cfg.UsageDatabaseURL != "" {
+		counter, err := newPostgresUsageCounter(ctx, cfg)
+		if err != nil {
+			logf("error", "usage counter init failed", map[string]interface{}{"err": err.Error()})
+			os.Exit(1)
+		}
+		defer counter.Close()
+		uses = counter
+	}
+
+	// Country lookups for geo-targeting rules and analytics.
+	var geo *geoIP
+	if cfg.GeoIPDatabase != "" {
+		geo, err = newWatchedGeoIP(ctx, cfg, logf)
+		if err != nil {
+			logf("error", "geoip init failed", map[string]interface{}{"path": cfg.GeoIPDatabase, "err": err.Error()})
+			os.Exit(1)
+		}
+	}
+
+	// Destination hosts that are never redirected to.
+	var blocked *blocklist
+	if cfg.BlocklistFile != "" {
+		blocked, err = newWatchedBlocklist(ctx, cfg, logf)
+		if err != nil {
+			logf("error", "blocklist init failed", map[string]interface{}{"path": cfg.BlocklistFile, "err": err.Error()})
+			os.Exit(1)
+		}
 	}
 
 	// Start analytics sink worker (bounded queue).
@@ -310,6 +682,22 @@ func main() {
 	sink := newAnalyticsSink(cfg, logf, otelhttp.NewTransport(http.DefaultTransport))
 	sink.Start(ctx)
 
+	// Preload hot codes before reporting ready so a fresh pod doesn't send
+	// its first burst of traffic straight to the backend.
+	var ready atomic.Bool
+	if cfg.WarmupSource == "" {
+		ready.Store(true)
+	} else {
+		warmupClient := &http.Client{
+			Timeout:   cfg.WarmupTimeout,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		}
+		go func() {
+			runWarmup(ctx, cfg, resolver, warmupClient, logf)
+			ready.Store(true)
+		}()
+	}
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -332,72 +720,68 @@ func main() {
 			http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
-	})
-
-	// Redirect handler: /r/{code}
-	mux.HandleFunc("/r/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet && r.Method != http.MethodHead {
-			http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		if !ready.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "warming_up"})
 			return
 		}
-
-		rid := requestIDFromContext(r.Context())
-
-		code := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/r/"))
-		if code == "" || len(code) > 64 {
-			http.Error(w, "invalid_code", http.StatusBadRequest)
-			return
+		// The breaker state is informational: an open breaker must not take
+		// the pod out of rotation, since cached and stale entries can still
+		// be served. It only applies to the http resolver.
+		resp := map[string]string{"status": "ready"}
+		if cfg.Resolver == resolverHTTP {
+			resp["circuit_breaker"] = breaker.State().String()
 		}
+		writeJSON(w, http.StatusOK, resp)
+	})
 
-		dest, status, err := resolveLongURL(resolveClient, cfg.BaseURL, code, rid)
-		if err != nil {
-			logf("error", "resolve failed", map[string]interface{}{
-				"code":   code,
-				"status": status,
-				"err":    err.Error(),
-				"request_id": rid,
-			})
-			http.Error(w, "bad_gateway", http.StatusBadGateway)
-			return
-		}
-		if status == http.StatusNotFound || dest == "" {
-			http.Error(w, "not_found", http.StatusNotFound)
-			return
-		}
+	// Without a configured secret, unlock cookies are signed with a
+	// per-process key and only work on the replica that issued them.
+	if len(cfg.UnlockCookieSecret) == 0 {
+		cfg.UnlockCookieSecret = make([]byte, 32)
+		_, _ = rand.Read(cfg.UnlockCookieSecret)
+		logf("info", "UNLOCK_COOKIE_SECRET not set (using a random per-process key)", map[string]interface{}{})
+	}
 
-		// Emit analytics event asynchronously (best-effort).
-		ref := strings.TrimSpace(r.Referer())
-		evt := analyticsEvent{
-			Code:      code,
-			TS:        time.Now().Unix(),
-			UserAgent: r.UserAgent(),
-			RequestID: rid,
-		}
-		if isHTTPURL(ref) {
-			evt.Referrer = ref
-		}
-		if ok := sink.Enqueue(evt); !ok {
-			logf("error", "analytics queue full (event dropped)", map[string]interface{}{
-				"code": code,
-				"request_id": rid,
-			})
-		}
+	// Redirect handler: /r/{code}, plus an optional path suffix. Links to
+	// internal addresses are refused whichever backend, cache entry or
+	// snapshot they come from.
+	redirect := &redirectHandler{
+		resolver:         &destinationGuard{next: resolver, allow: cfg.DestinationAllowlist},
+		sink:             sink,
+		uses:             uses,
+		gate:             newPasswordGate(cfg, logf),
+		geo:              geo,
+		logf:             logf,
+		defaultStatus:    cfg.DefaultRedirectStatus,
+		permanentMaxAge:  cfg.PermanentRedirectTTL,
+		trustProxy:       cfg.TrustProxy,
+		queryPassthrough: cfg.QueryPassthrough,
+		pathPassthrough:  cfg.PathPassthrough,
+		injectParams:     cfg.InjectParams,
+		injectConflict:   cfg.InjectConflict,
+		tenants:          cfg.Tenants,
+		blocklist:        blocked,
+		blockAction:      cfg.BlocklistAction,
+	}
+	mux.Handle("/r/", redirect)
 
-		logf("info", "redirect", map[string]interface{}{
-			"code": code,
-			"to":   dest,
-			"ua":   r.UserAgent(),
-			"request_id": rid,
-		})
+	// QR codes: /qr/{code}
+	if cfg.PublicBaseURL != "" {
+		mux.Handle("/qr/", &qrHandler{redirect: redirect, baseURL: cfg.PublicBaseURL, rootPathLinks: cfg.RootPathLinks, logf: logf})
+	} else {
+		logf("info", "PUBLIC_BASE_URL not set (QR codes disabled)", map[string]interface{}{})
+	}
 
-		http.Redirect(w, r, dest, http.StatusFound)
-	})
+	// Internal cache invalidation. Only mounted when a token is configured.
+	// With host-based ingress routing it is publicly reachable, so the
+	// token is its only protection.
+	if cfg.InvalidationToken != "" {
+		mux.Handle("/internal/invalidate", &invalidateHandler{resolver: resolver, token: cfg.InvalidationToken, logf: logf})
+	}
 
-	// Default 404 with minimal info (don’t leak).
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "not_found", http.StatusNotFound)
-	})
+	// Default 404 with minimal info (don’t leak), or /{code} links with
+	// ROOT_PATH_LINKS. Registered routes always take precedence.
+	mux.Handle("/", rootHandler(redirect, cfg.RootPathLinks))
 
 	// Expose Prometheus metrics. Registered directly on the mux so it bypasses
 	// withMetrics to avoid recording observations about the scrape itself.
@@ -426,6 +810,7 @@ func main() {
 		withRequestID(
 			withMetrics(
 				withRequestLogging(mux, logf),
+				cfg.RootPathLinks,
 			),
 		),
 		"redirect-service",
@@ -464,7 +849,7 @@ func main() {
 	logf("info", "server stopped", nil)
 }
 
-func withMetrics(next http.Handler) http.Handler {
+func withMetrics(next http.Handler, rootPathLinks bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip recording metrics for the /metrics endpoint itself.
 		if r.URL.Path == "/metrics" {
@@ -477,18 +862,7 @@ func withMetrics(next http.Handler) http.Handler {
 		next.ServeHTTP(ww, r)
 
 		duration := time.Since(start).Seconds()
-		// Normalise route: collapse /r/<code> to /r/{code} to avoid high cardinality.
-		// Anything else that doesn't match a known route is collapsed to "unknown"
-		// to prevent bot/scanner paths from creating unbounded label cardinality.
-		route := "unknown"
-		switch {
-		case r.URL.Path == "/health":
-			route = "/health"
-		case r.URL.Path == "/ready":
-			route = "/ready"
-		case len(r.URL.Path) > 3 && r.URL.Path[:3] == "/r/":
-			route = "/r/{code}"
-		}
+		route := routeLabel(r, rootPathLinks)
 		statusStr := strconv.Itoa(ww.status)
 
 		httpRequestsTotal.WithLabelValues(r.Method, route, statusStr).Inc()
@@ -505,6 +879,28 @@ func withMetrics(next http.Handler) http.Handler {
 	})
 }
 
+// routeLabel normalises the request path for metrics: /r/<code> collapses
+// to /r/{code} (and root-path links to /{code}) to avoid high cardinality.
+// Anything else that doesn't match a known route is collapsed to "unknown"
+// to prevent bot/scanner paths from creating unbounded label cardinality.
+func routeLabel(r *http.Request, rootPathLinks bool) string {
+	switch {
+	case r.URL.Path == "/health":
+		return "/health"
+	case r.URL.Path == "/ready":
+		return "/ready"
+	case r.URL.Path == "/internal/invalidate":
+		return "/internal/invalidate"
+	case len(r.URL.Path) > 3 && r.URL.Path[:3] == "/r/":
+		return "/r/{code}"
+	case strings.HasPrefix(r.URL.Path, "/qr/"):
+		return "/qr/{code}"
+	case rootPathLinks && isRootLinkPath(r.URL.EscapedPath()):
+		return "/{code}"
+	}
+	return "unknown"
+}
+
 func withRequestLogging(next http.Handler, logf func(level, msg string, fields map[string]interface{})) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -513,10 +909,10 @@ func withRequestLogging(next http.Handler, logf func(level, msg string, fields m
 		next.ServeHTTP(ww, r)
 
 		logf("info", "request", map[string]interface{}{
-			"method": r.Method,
-			"path":   r.URL.Path,
-			"status": ww.status,
-			"ms":     time.Since(start).Milliseconds(),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     ww.status,
+			"ms":         time.Since(start).Milliseconds(),
 			"request_id": requestIDFromContext(r.Context()),
 		})
 	})
@@ -22,4 +22,149 @@ var (
 		},
 		[]string{"method", "route", "status_code"},
 	)
+
+	resolveCacheHitsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "resolve_cache_hits_total",
+			Help: "Total number of resolve cache lookups served from cache",
+		},
+		[]string{"cache"},
+	)
+
+	resolveCacheMissesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "resolve_cache_misses_total",
+			Help: "Total number of resolve cache lookups not found or expired",
+		},
+		[]string{"cache"},
+	)
+
+	resolveCacheEvictionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "resolve_cache_evictions_total",
+			Help: "Total number of resolve cache entries evicted to stay within the size bound",
+		},
+		[]string{"cache"},
+	)
+
+	resolveCacheEntries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "resolve_cache_entries",
+			Help: "Current number of entries held in the resolve cache",
+		},
+		[]string{"cache"},
+	)
+
+	resolveCoalescedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "resolve_coalesced_requests_total",
+			Help: "Total number of resolve lookups that shared an in-flight url-service request instead of issuing their own",
+		},
+	)
+
+	resolveStaleServedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "resolve_stale_served_total",
+			Help: "Total number of resolutions served from a cache entry past its TTL while revalidating in the background",
+		},
+	)
+
+	urlServiceBreakerState = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "url_service_circuit_breaker_state",
+			Help: "State of the url-service circuit breaker (0=closed, 1=half-open, 2=open)",
+		},
+	)
+
+	cacheInvalidationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "resolve_cache_invalidations_total",
+			Help: "Total number of resolve cache invalidations (codes, or 1 per wildcard flush)",
+		},
+		[]string{"source"},
+	)
+
+	fileReloadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "file_reloads_total",
+			Help: "Total number of hot reloads of watched local files",
+		},
+		[]string{"file", "result"},
+	)
+
+	resolveFallbackServedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "resolve_fallback_served_total",
+			Help: "Total number of resolutions answered from the snapshot fallback after the primary resolver failed",
+		},
+	)
+
+	resolverSnapshotEntries = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "resolver_snapshot_entries",
+			Help: "Number of links in the currently loaded resolver snapshot",
+		},
+	)
+
+	redirectsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "redirects_total",
+			Help: "Total number of redirects issued, by HTTP status",
+		},
+		[]string{"status_code"},
+	)
+
+	linkUsesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "link_uses_total",
+			Help: "Total number of max_uses checks on click-limited links, by result (consumed, exhausted or error)",
+		},
+		[]string{"result"},
+	)
+
+	passwordAttemptsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "password_attempts_total",
+			Help: "Total number of password submissions for protected links, by result (ok, failed or limited)",
+		},
+		[]string{"result"},
+	)
+
+	linkPreviewsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "link_previews_total",
+			Help: "Total number of link previews served, by safety verdict (ok, warning or blocked; empty for protected links)",
+		},
+		[]string{"verdict"},
+	)
+
+	qrCodesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "qr_codes_total",
+			Help: "Total number of QR code images rendered (excluding 304 responses), by format",
+		},
+		[]string{"format"},
+	)
+
+	tenantRejectedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tenant_host_rejected_total",
+			Help: "Total number of requests rejected with 421 because their Host is not in TENANTS",
+		},
+	)
+
+	blockedRedirectsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "blocked_redirects_total",
+			Help: "Total number of clicks not redirected because the destination is on the blocklist, by entry kind (domain, suffix or regex) and action (block or warn)",
+		},
+		[]string{"kind", "action"},
+	)
+
+	blocklistEntries = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "blocklist_entries",
+			Help: "Number of entries in the currently loaded destination blocklist",
+		},
+	)
 )
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Query passthrough modes, per link (query_passthrough) or service-wide
+// (QUERY_PASSTHROUGH). They decide what happens to the short link's own
+// query string, e.g. the utm_* parameters of /r/abc?utm_source=x.
+const (
+	queryPassOff      = "off"      // dropped
+	queryPassMerge    = "merge"    // added; the destination's own values win
+	queryPassOverride = "override" // added; they replace the destination's
+)
+
+func isQueryPassthroughMode(s string) bool {
+	return s == queryPassOff || s == queryPassMerge || s == queryPassOverride
+}
+
+var errDotSegment = errors.New("path suffix contains a dot segment")
+
+// splitRedirectPath splits the escaped request path, /r/... or a root-path
+// link /..., into the code and whatever follows it. The code ends at the
+// first '/', so "/r/abc/x/y" is code "abc" with suffix "/x/y". The suffix
+// keeps its leading slash and its escaping, so an encoded "%2F" is
+// forwarded as such.
+//
+// A literal trailing '+' without a suffix asks for a preview of the code
+// before it. It is matched before unescaping, so "/r/abc%2B" looks up the
+// code "abc+" instead.
+//
+// Suffixes with "." or ".." segments (in any encoding) are rejected:
+// browsers resolve them, which would let a visitor climb out of the
+// destination path the link owner chose.
+func splitRedirectPath(escaped string) (code, suffix string, preview bool, err error) {
+	rest, ok := strings.CutPrefix(escaped, "/r/")
+	if !ok {
+		rest = strings.TrimPrefix(escaped, "/")
+	}
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest, suffix = rest[:i], rest[i:]
+	} else {
+		rest, preview = strings.CutSuffix(rest, "+")
+	}
+	code, err = url.PathUnescape(rest)
+	if err != nil {
+		return "", "", false, err
+	}
+	for _, seg := range strings.Split(suffix, "/") {
+		seg, err := url.PathUnescape(seg)
+		if err != nil {
+			return "", "", false, err
+		}
+		if seg == "." || seg == ".." {
+			return "", "", false, errDotSegment
+		}
+	}
+	return strings.TrimSpace(code), suffix, preview, nil
+}
+
+// applyPassthrough forwards the request's path suffix and query string to
+// dest. suffix is appended to the destination path; mode decides whether
+// and how rawQuery is merged into the destination query. The destination's
+// own encoding and fragment are left as they are. An empty mode is off.
+func applyPassthrough(dest, suffix, rawQuery, mode string) (string, error) {
+	forwardQuery := rawQuery != "" && (mode == queryPassMerge || mode == queryPassOverride)
+	if suffix == "" && !forwardQuery {
+		return dest, nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", err
+	}
+	if suffix != "" {
+		p := strings.TrimSuffix(u.EscapedPath(), "/") + suffix
+		if u.Path, err = url.PathUnescape(p); err != nil {
+			return "", err
+		}
+		u.RawPath = p
+	}
+	if forwardQuery {
+		u.RawQuery = mergeQuery(u.RawQuery, rawQuery, mode)
+	}
+	return u.String(), nil
+}
+
+// mergeQuery combines a destination query with an incoming one, keeping
+// parameter order. Destination pairs are kept verbatim. Incoming pairs are
+// decoded and re-encoded so the Location header only ever carries a
+// well-formed query; pairs that don't decode are dropped. Keys are
+// compared decoded, and a repeated key counts as one parameter.
+func mergeQuery(destRaw, reqRaw, mode string) string {
+	type pair struct{ key, raw string }
+	var in []pair
+	inKeys := map[string]bool{}
+	for _, p := range strings.Split(reqRaw, "&") {
+		if p == "" {
+			continue
+		}
+		k, v, hasValue := strings.Cut(p, "=")
+		k, err := url.QueryUnescape(k)
+		if err != nil {
+			continue
+		}
+		v, err = url.QueryUnescape(v)
+		if err != nil {
+			continue
+		}
+		raw := url.QueryEscape(k)
+		if hasValue {
+			raw += "=" + url.QueryEscape(v)
+		}
+		in = append(in, pair{k, raw})
+		inKeys[k] = true
+	}
+	if len(in) == 0 {
+		return destRaw
+	}
+
+	var out []string
+	destKeys := map[string]bool{}
+	for _, p := range strings.Split(destRaw, "&") {
+		if p == "" {
+			continue
+		}
+		k, _, _ := strings.Cut(p, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if mode == queryPassOverride && inKeys[k] {
+			continue
+		}
+		destKeys[k] = true
+		out = append(out, p)
+	}
+	for _, p := range in {
+		if mode == queryPassMerge && destKeys[p.key] {
+			continue
+		}
+		out = append(out, p.raw)
+	}
+	return strings.Join(out, "&")
+}
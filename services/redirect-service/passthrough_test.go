@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSplitRedirectPath(t *testing.T) {
+	cases := []struct {
+		path         string
+		code, suffix string
+		preview      bool
+		wantErr      bool
+	}{
+		{"/r/abc", "abc", "", false, false},
+		{"/r/abc/", "abc", "/", false, false},
+		{"/r/abc/extra/path", "abc", "/extra/path", false, false},
+		{"/r/a%20b/x", "a b", "/x", false, false},
+		{"/r/abc/a%2Fb", "abc", "/a%2Fb", false, false}, // encoded slash stays in the suffix
+		{"/r/abc/caf%C3%A9", "abc", "/caf%C3%A9", false, false},
+		{"/r/abc/..", "", "", false, true},
+		{"/r/abc/x/../y", "", "", false, true},
+		{"/r/abc/%2e%2E/admin", "", "", false, true},
+		{"/r/abc/.%2e", "", "", false, true},
+		{"/r/abc/%2e", "", "", false, true},
+		{"/r/abc/..x", "abc", "/..x", false, false},
+		{"/r/ab%zz", "", "", false, true},
+		{"/abc", "abc", "", false, false}, // root-path link
+		{"/abc/x", "abc", "/x", false, false},
+		{"/r/abc+", "abc", "", true, false},
+		{"/r/abc%2B", "abc+", "", false, false}, // an encoded '+' is part of the code
+		{"/r/abc%2b+", "abc+", "", true, false},
+		{"/r/abc+/x", "abc+", "/x", false, false},
+		{"/abc+", "abc", "", true, false},
+	}
+	for _, tc := range cases {
+		code, suffix, preview, err := splitRedirectPath(tc.path)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: unexpected error %v", tc.path, err)
+		}
+		if code != tc.code || suffix != tc.suffix || preview != tc.preview {
+			t.Fatalf("%s: expected (%q, %q, %v), got (%q, %q, %v)", tc.path, tc.code, tc.suffix, tc.preview, code, suffix, preview)
+		}
+	}
+}
+
+func TestApplyPassthrough(t *testing.T) {
+	cases := []struct {
+		name                         string
+		dest, suffix, rawQuery, mode string
+		want                         string
+	}{
+		{"off drops the query", "https://example.com/p?a=1", "", "utm_source=x", queryPassOff, "https://example.com/p?a=1"},
+		{"empty mode is off", "https://example.com/p", "", "utm_source=x", "", "https://example.com/p"},
+		{"merge into empty query", "https://example.com/p", "", "utm_source=x&utm_medium=y", queryPassMerge, "https://example.com/p?utm_source=x&utm_medium=y"},
+		{"merge keeps destination values", "https://example.com/p?a=1&b=2", "", "b=9&c=3", queryPassMerge, "https://example.com/p?a=1&b=2&c=3"},
+		{"override replaces destination values", "https://example.com/p?a=1&b=2&b=3", "", "b=9&c=3", queryPassOverride, "https://example.com/p?a=1&b=9&c=3"},
+		{"repeated incoming keys kept", "https://example.com/", "", "t=1&t=2", queryPassMerge, "https://example.com/?t=1&t=2"},
+		{"keys compared decoded", "https://example.com/?a%20b=1", "", "a+b=2", queryPassMerge, "https://example.com/?a%20b=1"},
+		{"incoming re-encoded", "https://example.com/", "", "q=a%20b+c&x=%26&y=;", queryPassMerge, "https://example.com/?q=a+b+c&x=%26&y=%3B"},
+		{"undecodable pairs dropped", "https://example.com/", "", "bad=%zz&ok=1", queryPassMerge, "https://example.com/?ok=1"},
+		{"flags without a value", "https://example.com/?debug", "", "verbose&debug", queryPassMerge, "https://example.com/?debug&verbose"},
+		{"empty pairs ignored", "https://example.com/?a=1&&", "", "&&b=2&", queryPassMerge, "https://example.com/?a=1&b=2"},
+		{"destination encoding kept", "https://example.com/a%2Fb?x=%2F", "", "y=1", queryPassMerge, "https://example.com/a%2Fb?x=%2F&y=1"},
+		{"fragment kept after the query", "https://example.com/p?a=1#top", "", "b=2", queryPassMerge, "https://example.com/p?a=1&b=2#top"},
+		{"suffix onto empty path", "https://example.com", "/extra/path", "", queryPassOff, "https://example.com/extra/path"},
+		{"suffix onto trailing slash", "https://example.com/docs/", "/a", "", queryPassOff, "https://example.com/docs/a"},
+		{"suffix onto path", "https://example.com/docs", "/a/", "", queryPassOff, "https://example.com/docs/a/"},
+		{"suffix escaping kept", "https://example.com/docs", "/a%2Fb/caf%C3%A9", "", queryPassOff, "https://example.com/docs/a%2Fb/caf%C3%A9"},
+		{"suffix with query and fragment", "https://example.com/docs?v=2#s", "/x", "v=3", queryPassOverride, "https://example.com/docs/x?v=3#s"},
+		{"suffix keeps host", "https://example.com", "//evil.example/x", "", queryPassOff, "https://example.com//evil.example/x"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := applyPassthrough(tc.dest, tc.suffix, tc.rawQuery, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerPassthrough(t *testing.T) {
+	on, off := true, false
+	links := map[string]resolveResp{
+		"plain": {LongURL: "https://example.com/landing?ref=short"},
+		"merge": {LongURL: "https://example.com/landing?ref=short", QueryPassthrough: queryPassMerge, PathPassthrough: &on},
+		"nope":  {LongURL: "https://example.com/landing", QueryPassthrough: queryPassOff, PathPassthrough: &off},
+	}
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr, ok := links[code]
+		if !ok {
+			return resolveResp{}, errNotFound
+		}
+		rr.Code = code
+		return rr, nil
+	})
+	h, _ := newTestRedirectHandler(resolver)
+	h.sink = newAnalyticsSink(Config{AnalyticsQueueLen: 64}, h.logf, nil)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+
+	// Off by default: the query is dropped and a suffix is not found.
+	if loc := get("/r/plain?utm_source=x").Header().Get("Location"); loc != "https://example.com/landing?ref=short" {
+		t.Fatalf("expected query to be dropped, got %s", loc)
+	}
+	if rr := get("/r/plain/extra"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a suffix without passthrough, got %d", rr.Code)
+	}
+
+	// Per-link settings.
+	if loc := get("/r/merge/a%2Fb?ref=mail&utm_source=x").Header().Get("Location"); loc != "https://example.com/landing/a%2Fb?ref=short&utm_source=x" {
+		t.Fatalf("unexpected merged destination %s", loc)
+	}
+	if rr := get("/r/merge/%2e%2e/admin"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a dot segment, got %d", rr.Code)
+	}
+
+	// Service-wide defaults apply unless the link overrides them.
+	h.queryPassthrough, h.pathPassthrough = queryPassOverride, true
+	if loc := get("/r/plain/x?ref=mail").Header().Get("Location"); loc != "https://example.com/landing/x?ref=mail" {
+		t.Fatalf("expected defaults to apply, got %s", loc)
+	}
+	if rr := get("/r/nope/x"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected link to opt out of path passthrough, got %d", rr.Code)
+	}
+	if loc := get("/r/nope?ref=mail").Header().Get("Location"); loc != "https://example.com/landing" {
+		t.Fatalf("expected link to opt out of query passthrough, got %s", loc)
+	}
+}
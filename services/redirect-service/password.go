@@ -0,0 +1,255 @@
+package main
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unlockCookiePrefix is followed by the code, so unlocking one link never
+// unlocks another.
+const unlockCookiePrefix = "rs_unlock_"
+
+// passwordGate guards links that carry a password_hash. Until a visitor has
+// a valid unlock cookie for the code, GET and HEAD get an HTML password
+// form and a POST of the form is checked against the hash. A correct
+// password sets a short-lived HMAC-signed cookie scoped to the link.
+type passwordGate struct {
+	secret     []byte
+	cookieTTL  time.Duration
+	secure     bool
+	trustProxy bool
+	limiter    *failureLimiter
+	logf       func(level, msg string, fields map[string]interface{})
+	now        func() time.Time
+}
+
+func newPasswordGate(cfg Config, logf func(level, msg string, fields map[string]interface{})) *passwordGate {
+	return &passwordGate{
+		secret:     cfg.UnlockCookieSecret,
+		cookieTTL:  cfg.UnlockCookieTTL,
+		secure:     cfg.UnlockCookieSecure,
+		trustProxy: cfg.TrustProxy,
+		limiter:    newFailureLimiter(cfg.PasswordMaxFailures, cfg.PasswordFailureWindow),
+		logf:       logf,
+		now:        time.Now,
+	}
+}
+
+// Allow reports whether the request may be redirected. If not, it has
+// already written the response: the form, a rejection, or 429.
+func (g *passwordGate) Allow(w http.ResponseWriter, r *http.Request, rr resolveResp) bool {
+	if g.validCookie(r, rr) {
+		return true
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		renderPasswordForm(w, r, http.StatusOK, "")
+		return false
+	}
+
+	rid := requestIDFromContext(r.Context())
+	key := clientIP(r, g.trustProxy) + "|" + linkKey(rr.Namespace, rr.Code)
+	if retry := g.limiter.Blocked(key); retry > 0 {
+		passwordAttemptsTotal.WithLabelValues("limited").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Round(time.Second).Seconds())))
+		renderPasswordForm(w, r, http.StatusTooManyRequests, "Too many attempts. Try again later.")
+		return false
+	}
+
+	password := r.PostFormValue("password")
+	if password == "" || !comparePassword(rr.PasswordHash, password) {
+		g.limiter.Fail(key)
+		passwordAttemptsTotal.WithLabelValues("failed").Inc()
+		g.logf("info", "password attempt failed", map[string]interface{}{
+			"code":       rr.Code,
+			"ip":         clientIP(r, g.trustProxy),
+			"request_id": rid,
+		})
+		renderPasswordForm(w, r, http.StatusUnauthorized, "Incorrect password.")
+		return false
+	}
+
+	g.limiter.Reset(key)
+	passwordAttemptsTotal.WithLabelValues("ok").Inc()
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookiePrefix + rr.Code,
+		Value:    g.sign(rr, g.now().Add(g.cookieTTL)),
+		Path:     linkPrefix(r) + url.PathEscape(rr.Code),
+		MaxAge:   int(g.cookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   g.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true
+}
+
+// sign returns "<expiry>.<mac>". The MAC covers the password hash too, so
+// changing a link's password revokes every cookie issued for it.
+func (g *passwordGate) sign(rr resolveResp, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + base64.RawURLEncoding.EncodeToString(g.mac(rr, exp))
+}
+
+func (g *passwordGate) mac(rr resolveResp, exp string) []byte {
+	m := hmac.New(sha256.New, g.secret)
+	m.Write([]byte(rr.Code + "\x00" + rr.PasswordHash + "\x00" + exp))
+	return m.Sum(nil)
+}
+
+func (g *passwordGate) validCookie(r *http.Request, rr resolveResp) bool {
+	c, err := r.Cookie(unlockCookiePrefix + rr.Code)
+	if err != nil {
+		return false
+	}
+	exp, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !g.now().Before(time.Unix(unix, 0)) {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, g.mac(rr, exp))
+}
+
+var passwordFormTmpl = template.Must(template.New("password").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<main>
+<h1>This link is password protected</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+func renderPasswordForm(w http.ResponseWriter, r *http.Request, status int, errMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = passwordFormTmpl.Execute(w, struct{ Action, Error string }{r.URL.RequestURI(), errMsg})
+}
+
+// failureLimiter counts failed attempts per key in a fixed window. It is
+// per replica, so the effective limit scales with the replica count.
+// Windows all have the same length, so they are kept in the order they
+// started, which is also the order they expire in.
+type failureLimiter struct {
+	max     int
+	window  time.Duration
+	maxKeys int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *failureWindow
+	order   *list.List               // oldest window first
+}
+
+type failureWindow struct {
+	key   string
+	count int
+	until time.Time
+}
+
+// maxFailureKeys bounds the limiter's memory. Once it is reached, the
+// oldest window is dropped to make room for a new one, even if it has not
+// expired yet.
+const maxFailureKeys = 100_000
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:     max,
+		window:  window,
+		maxKeys: maxFailureKeys,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// Blocked returns how long key stays blocked, or 0 if it may try again.
+func (l *failureLimiter) Blocked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	e := el.Value.(*failureWindow)
+	now := l.now()
+	if !now.Before(e.until) {
+		l.remove(el)
+		return 0
+	}
+	if e.count < l.max {
+		return 0
+	}
+	return e.until.Sub(now)
+}
+
+// Fail records a failed attempt for key.
+func (l *failureLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if el, ok := l.entries[key]; ok {
+		if e := el.Value.(*failureWindow); now.Before(e.until) {
+			e.count++
+			return
+		}
+		l.remove(el)
+	}
+	l.prune(now)
+	for l.order.Len() >= l.maxKeys {
+		l.remove(l.order.Front())
+	}
+	l.entries[key] = l.order.PushBack(&failureWindow{key: key, count: 1, until: now.Add(l.window)})
+}
+
+// Reset forgets key's failures after a successful attempt.
+func (l *failureLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.entries[key]; ok {
+		l.remove(el)
+	}
+}
+
+// prune drops expired windows from the front of the queue.
+func (l *failureLimiter) prune(now time.Time) {
+	for el := l.order.Front(); el != nil && !now.Before(el.Value.(*failureWindow).until); el = l.order.Front() {
+		l.remove(el)
+	}
+}
+
+func (l *failureLimiter) remove(el *list.Element) {
+	delete(l.entries, el.Value.(*failureWindow).key)
+	l.order.Remove(el)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func protectedResolver(t *testing.T, password string) (Resolver, *resolveResp) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := &resolveResp{LongURL: "https://example.com/secret", PasswordHash: string(hash)}
+	return resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr := *link
+		rr.Code = code
+		return rr, nil
+	}), link
+}
+
+func postPassword(h http.Handler, code, password, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/r/"+code, strings.NewReader(url.Values{"password": {password}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	resolver, link := protectedResolver(t, "hunter2")
+	h, sink := newTestRedirectHandler(resolver)
+
+	// GET without a cookie shows the form and doesn't redirect.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/r/doc", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `<form method="post" action="/r/doc">`) {
+		t.Fatalf("expected password form, got %d %q", rr.Code, rr.Body.String())
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected form not to be cacheable, got %q", cc)
+	}
+
+	if rr := postPassword(h, "doc", "wrong", "192.0.2.1:1234"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong password, got %d", rr.Code)
+	}
+	if len(sink.ch) != 0 {
+		t.Fatal("expected no analytics event before unlock")
+	}
+
+	rr = postPassword(h, "doc", "hunter2", "192.0.2.1:1234")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "https://example.com/secret" {
+		t.Fatalf("expected 303 to the destination, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "rs_unlock_doc" || cookies[0].Path != "/r/doc" || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("unexpected unlock cookie: %+v", cookies)
+	}
+	cookie := cookies[0]
+
+	get := func(code string, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/r/"+code, nil)
+		req.AddCookie(c)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The cookie unlocks GETs on this code only.
+	if rr := get("doc", cookie); rr.Code != http.StatusFound {
+		t.Fatalf("expected unlocked GET to redirect, got %d", rr.Code)
+	}
+	if rr := get("other", &http.Cookie{Name: "rs_unlock_other", Value: cookie.Value}); rr.Code != http.StatusOK {
+		t.Fatalf("expected cookie not to unlock another code, got %d", rr.Code)
+	}
+	tampered := *cookie
+	tampered.Value = strings.Replace(cookie.Value, ".", "9.", 1)
+	if rr := get("doc", &tampered); rr.Code != http.StatusOK {
+		t.Fatalf("expected tampered cookie to be rejected, got %d", rr.Code)
+	}
+
+	// The cookie expires.
+	h.gate.now = func() time.Time { return time.Now().Add(16 * time.Minute) }
+	if rr := get("doc", cookie); rr.Code != http.StatusOK {
+		t.Fatalf("expected expired cookie to be rejected, got %d", rr.Code)
+	}
+	h.gate.now = time.Now
+
+	// Changing the password revokes existing cookies.
+	hash, _ := bcrypt.GenerateFromPassword([]byte("new"), bcrypt.MinCost)
+	link.PasswordHash = string(hash)
+	if rr := get("doc", cookie); rr.Code != http.StatusOK {
+		t.Fatalf("expected cookie to be revoked by a password change, got %d", rr.Code)
+	}
+}
+
+func TestPasswordAttemptsAreRateLimited(t *testing.T) {
+	resolver, _ := protectedResolver(t, "hunter2")
+	h, _ := newTestRedirectHandler(resolver)
+
+	// The test gate allows 3 failures per IP and code.
+	for i := range 3 {
+		if rr := postPassword(h, "doc", "wrong", "192.0.2.1:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rr.Code)
+		}
+	}
+	rr := postPassword(h, "doc", "hunter2", "192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After even for the right password, got %d", rr.Code)
+	}
+
+	// Other clients and other codes are unaffected.
+	if rr := postPassword(h, "doc", "hunter2", "192.0.2.2:1234"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected another IP to unlock, got %d", rr.Code)
+	}
+	if rr := postPassword(h, "other", "hunter2", "192.0.2.1:1234"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected another code to unlock, got %d", rr.Code)
+	}
+
+	// The block lifts when the window ends.
+	h.gate.limiter.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if rr := postPassword(h, "doc", "hunter2", "192.0.2.1:1234"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected unlock after the window, got %d", rr.Code)
+	}
+}
+
+func TestFailureLimiterBounded(t *testing.T) {
+	l := newFailureLimiter(1, time.Minute)
+	l.maxKeys = 3
+	start := time.Now()
+	for i, key := range []string{"a", "b", "c", "d"} {
+		l.now = func() time.Time { return start.Add(time.Duration(i) * time.Second) }
+		l.Fail(key)
+	}
+	if len(l.entries) != 3 || l.order.Len() != 3 {
+		t.Fatalf("expected 3 tracked keys, got %d/%d", len(l.entries), l.order.Len())
+	}
+	// The oldest window made room for the newest, which is still blocked.
+	if l.Blocked("a") != 0 {
+		t.Fatal("expected the oldest key to be evicted")
+	}
+	for _, key := range []string{"b", "c", "d"} {
+		if l.Blocked(key) == 0 {
+			t.Fatalf("expected %s to stay blocked", key)
+		}
+	}
+
+	// Expired windows are dropped before live ones.
+	l.now = func() time.Time { return start.Add(time.Minute + 1500*time.Millisecond) }
+	l.Fail("e")
+	if len(l.entries) != 3 || l.Blocked("c") == 0 || l.Blocked("d") == 0 {
+		t.Fatalf("expected only the expired window to go, got %d keys", len(l.entries))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name       string
+		xff        []string
+		trustProxy bool
+		want       string
+	}{
+		{"remote addr", nil, false, "192.0.2.1"},
+		{"untrusted header ignored", []string{"203.0.113.9"}, false, "192.0.2.1"},
+		{"trusted proxy", []string{"203.0.113.9"}, true, "203.0.113.9"},
+		{"last hop wins", []string{"10.0.0.1, 203.0.113.9"}, true, "203.0.113.9"},
+		{"last header wins", []string{"10.0.0.1", "198.51.100.7"}, true, "198.51.100.7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:5555"
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(req, tc.trustProxy); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
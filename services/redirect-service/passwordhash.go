@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Link password hashes are either bcrypt ("$2a$10$...") or the scrypt
+// format url-service writes:
+//
+//	$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//
+// with unpadded standard base64 salt and hash.
+const scryptPrefix = "$scrypt$"
+
+// Bounds on scrypt parameters, so that a bad record can't make a single
+// password check take seconds or gigabytes. 128·2^ln·r bytes of memory
+// must stay within 64 MiB.
+const (
+	scryptMinLogN   = 10
+	scryptMaxMemory = 64 << 20
+	scryptMaxP      = 4
+	scryptMinSalt   = 8
+	scryptMinKey    = 16
+	scryptMaxKey    = 64
+)
+
+type scryptHash struct {
+	logN, r, p int
+	salt, key  []byte
+}
+
+func parseScryptHash(s string) (scryptHash, error) {
+	parts := strings.Split(strings.TrimPrefix(s, scryptPrefix), "$")
+	if len(parts) != 3 {
+		return scryptHash{}, errors.New("malformed scrypt hash")
+	}
+	var h scryptHash
+	if _, err := fmt.Sscanf(parts[0], "ln=%d,r=%d,p=%d", &h.logN, &h.r, &h.p); err != nil ||
+		fmt.Sprintf("ln=%d,r=%d,p=%d", h.logN, h.r, h.p) != parts[0] {
+		return scryptHash{}, errors.New("malformed scrypt parameters")
+	}
+	if h.logN < scryptMinLogN || h.logN > 30 || h.r < 1 || h.p < 1 || h.p > scryptMaxP ||
+		128*(1<<h.logN)*h.r > scryptMaxMemory {
+		return scryptHash{}, fmt.Errorf("scrypt parameters %s out of range", parts[0])
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil || len(h.salt) < scryptMinSalt {
+		return scryptHash{}, errors.New("invalid scrypt salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil || len(h.key) < scryptMinKey || len(h.key) > scryptMaxKey {
+		return scryptHash{}, errors.New("invalid scrypt hash")
+	}
+	return h, nil
+}
+
+// checkPasswordHash reports whether hash is a bcrypt or scrypt hash that
+// comparePassword can verify.
+func checkPasswordHash(hash string) error {
+	if strings.HasPrefix(hash, scryptPrefix) {
+		_, err := parseScryptHash(hash)
+		return err
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err
+}
+
+// comparePassword reports whether password matches hash.
+func comparePassword(hash, password string) bool {
+	if !strings.HasPrefix(hash, scryptPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	h, err := parseScryptHash(hash)
+	if err != nil {
+		return false
+	}
+	key, err := scrypt.Key([]byte(password), h.salt, 1<<h.logN, h.r, h.p, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
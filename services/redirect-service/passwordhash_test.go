@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testScryptHash is "hunter2" hashed the way url-service does it, with a
+// lower cost and a fixed salt.
+const testScryptHash = "$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$xhygCB++/lnqkJuXyqpuqIwyXp1fZuC+q3d168khIUA"
+
+func TestComparePassword(t *testing.T) {
+	const bcryptHash = "$2a$04$ero38spkdejVWFFMBehQWO4je339d/BirITk/ueZQmndYNUV4dBX."
+	for _, hash := range []string{bcryptHash, testScryptHash} {
+		if !comparePassword(hash, "hunter2") {
+			t.Fatalf("%s: expected the password to match", hash)
+		}
+		if comparePassword(hash, "hunter3") {
+			t.Fatalf("%s: expected a wrong password not to match", hash)
+		}
+	}
+	if comparePassword(bcryptHash, "") {
+		t.Fatal("expected an empty password not to match")
+	}
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	salt, key := "MDEyMzQ1Njc4OWFiY2RlZg", "xhygCB++/lnqkJuXyqpuqIwyXp1fZuC+q3d168khIUA"
+	if err := checkPasswordHash(testScryptHash); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{
+		"hunter2",
+		"$scrypt$",
+		"$scrypt$ln=10,r=8,p=1$" + salt,
+		"$scrypt$ln=10,r=8$" + salt + "$" + key,
+		"$scrypt$ln=10,r=8,p=1,x=1$" + salt + "$" + key,
+		"$scrypt$ln=9,r=8,p=1$" + salt + "$" + key,
+		"$scrypt$ln=20,r=8,p=1$" + salt + "$" + key,
+		"$scrypt$ln=10,r=8,p=5$" + salt + "$" + key,
+		"$scrypt$ln=10,r=8,p=1$" + salt + "==$" + key,
+		"$scrypt$ln=10,r=8,p=1$MDEy$" + key,
+		"$scrypt$ln=10,r=8,p=1$" + salt + "$" + strings.Repeat("A", 100),
+	} {
+		if err := checkPasswordHash(bad); err == nil {
+			t.Fatalf("%q: expected an error", bad)
+		}
+		if comparePassword(bad, "hunter2") {
+			t.Fatalf("%q: expected no match", bad)
+		}
+	}
+}
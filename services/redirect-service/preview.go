@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Safety verdict levels shown on the preview page.
+const (
+	verdictOK      = "ok"
+	verdictWarning = "warning"
+	verdictBlocked = "blocked" // on the local blocklist (BLOCKLIST_FILE)
+)
+
+// Reasons a destination is flagged, with the text the preview page shows.
+var verdictReasons = map[string]string{
+	"unparsable":               "The destination is not a valid URL.",
+	"not_https":                "The destination does not use HTTPS.",
+	"ip_address_host":          "The destination is an IP address rather than a domain name.",
+	"internationalized_domain": "The domain uses international characters, which can imitate another site.",
+	"non_standard_port":        "The destination uses a non-standard port.",
+	"blocklisted":              "The destination is on our list of known phishing and malware sites.",
+}
+
+// verdict is a heuristic assessment of a destination. It flags URLs that
+// deserve a second look; it does not prove a site safe.
+type verdict struct {
+	Level   string   `json:"level"`
+	Reasons []string `json:"reasons"`
+}
+
+func assessDestination(dest string) verdict {
+	v := verdict{Level: verdictOK, Reasons: []string{}}
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" {
+		v.Reasons = append(v.Reasons, "unparsable")
+	} else {
+		host := u.Hostname()
+		if !strings.EqualFold(u.Scheme, "https") {
+			v.Reasons = append(v.Reasons, "not_https")
+		}
+		if net.ParseIP(host) != nil {
+			v.Reasons = append(v.Reasons, "ip_address_host")
+		}
+		if strings.HasPrefix(strings.ToLower(host), "xn--") || strings.Contains(strings.ToLower(host), ".xn--") ||
+			strings.ContainsFunc(host, func(c rune) bool { return c > 0x7f }) {
+			v.Reasons = append(v.Reasons, "internationalized_domain")
+		}
+		if p := u.Port(); p != "" && p != "80" && p != "443" {
+			v.Reasons = append(v.Reasons, "non_standard_port")
+		}
+	}
+	if len(v.Reasons) > 0 {
+		v.Level = verdictWarning
+	}
+	return v
+}
+
+// linkPreview is the JSON body of a preview, and the data behind the HTML
+// page.
+type linkPreview struct {
+	Code        string     `json:"code"`
+	Destination string     `json:"destination,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	// Varies is set when targeting rules or rotation may send other
+	// visitors elsewhere.
+	Varies bool `json:"varies,omitempty"`
+	// Expired is set when the link has ended and Destination is its
+	// fallback_url.
+	Expired bool `json:"expired,omitempty"`
+	// Protected and click-limited (max_uses) links don't disclose their
+	// destination: a preview costs no use, so it would reveal it for free.
+	Protected   bool     `json:"protected,omitempty"`
+	Limited     bool     `json:"limited,omitempty"`
+	Verdict     *verdict `json:"verdict,omitempty"`
+	ContinueURL string   `json:"continue_url"`
+}
+
+// servePreview serves /r/{code}+: it resolves the code like a click would
+// and describes where it leads instead of redirecting. Nothing about the
+// link is consumed or assigned: max_uses is not touched, no variant cookie
+// is set, and the analytics event is a preview rather than a click.
+func (h *redirectHandler) servePreview(w http.ResponseWriter, r *http.Request, tenant, code string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if code == "" || len(code) > 64 {
+		http.Error(w, "invalid_code", http.StatusBadRequest)
+		return
+	}
+	rid := requestIDFromContext(r.Context())
+
+	rr, ok := h.resolve(w, r, tenant, code)
+	if !ok {
+		return
+	}
+
+	p := linkPreview{
+		Code:        code,
+		CreatedAt:   rr.CreatedAt,
+		ContinueURL: linkPrefix(r) + url.PathEscape(code),
+	}
+	if r.URL.RawQuery != "" {
+		p.ContinueURL += "?" + r.URL.RawQuery
+	}
+	vis := visitorFromRequest(r, h.geo, h.trustProxy)
+	variant := ""
+	switch rr.windowAt(h.clock()) {
+	case windowPending:
+		http.Error(w, "not_found", http.StatusNotFound)
+		return
+	case windowExpired:
+		if rr.FallbackURL == "" {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		p.Expired = true
+		p.Destination, variant = rr.FallbackURL, fallbackVariant
+	default:
+		if rr.PasswordHash != "" {
+			p.Protected = true
+			break
+		}
+		if rr.MaxUses > 0 {
+			p.Limited = true
+			break
+		}
+		p.Destination, variant = rr.target(vis)
+		if variant == defaultVariant && len(rr.Destinations) > 0 {
+			d, _ := pickDestination(r, code, rr.Destinations, h.trustProxy)
+			p.Destination, variant = d.URL, d.Variant
+		}
+		p.Varies = len(rr.Rules) > 0 || len(rr.Destinations) > 0
+	}
+	if p.Destination != "" {
+		v := assessDestination(p.Destination)
+		if h.blocklist != nil {
+			if _, blocked := h.blocklist.Match(p.Destination); blocked {
+				v.Level = verdictBlocked
+				v.Reasons = append(v.Reasons, "blocklisted")
+			}
+		}
+		p.Verdict = &v
+	}
+
+	evt := analyticsEvent{
+		Code:      code,
+		Tenant:    tenant,
+		Type:      eventPreview,
+		TS:        time.Now().Unix(),
+		UserAgent: r.UserAgent(),
+		RequestID: rid,
+		Variant:   variant,
+		Country:   vis.Country,
+	}
+	if ref := strings.TrimSpace(r.Referer()); isHTTPURL(ref) {
+		evt.Referrer = ref
+	}
+	if ok := h.sink.Enqueue(evt); !ok {
+		h.logf("error", "analytics queue full (event dropped)", map[string]interface{}{
+			"code":       code,
+			"request_id": rid,
+		})
+	}
+
+	level := ""
+	if p.Verdict != nil {
+		level = p.Verdict.Level
+	}
+	h.logf("info", "preview", map[string]interface{}{
+		"tenant":     tenant,
+		"code":       code,
+		"to":         p.Destination,
+		"variant":    variant,
+		"verdict":    level,
+		"protected":  p.Protected,
+		"limited":    p.Limited,
+		"request_id": rid,
+	})
+	linkPreviewsTotal.WithLabelValues(level).Inc()
+
+	// The verdict and destination can change at any time, so previews are
+	// never cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Vary", "Accept")
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_ = json.NewEncoder(w).Encode(p)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = previewTmpl.Execute(w, p)
+}
+
+// wantsJSON reports whether the client asked for JSON rather than HTML.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+var previewTmpl = template.Must(template.New("preview").Funcs(template.FuncMap{
+	"reason": func(r string) string { return verdictReasons[r] },
+	"date":   func(t *time.Time) string { return t.UTC().Format("2 January 2006") },
+}).Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+</head>
+<body>
+<main>
+<h1>Where this link goes</h1>
+{{if .Protected}}<p>This link is password protected. Its destination is shown after the password is entered.</p>
+{{else if .Limited}}<p>This link can only be followed a limited number of times, so its destination is not shown.</p>
+{{else}}<p><code>{{.Destination}}</code></p>
+{{if .Expired}}<p>This link has ended and now leads to the address above.</p>{{end}}
+{{if .Varies}}<p>This link may lead elsewhere depending on your device, language or location.</p>{{end}}
+{{end}}{{if .CreatedAt}}<p>Created {{date .CreatedAt}}</p>
+{{end}}{{with .Verdict}}{{if eq .Level "ok"}}<p>No warnings for this destination.</p>
+{{else}}<section role="alert">
+<h2>Check before continuing</h2>
+<ul>
+{{range .Reasons}}<li>{{reason .}}</li>
+{{end}}</ul>
+</section>
+{{end}}{{end}}<p><a href="{{.ContinueURL}}" rel="noreferrer">Continue</a></p>
+</main>
+</body>
+</html>
+`))
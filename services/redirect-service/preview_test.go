@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssessDestination(t *testing.T) {
+	cases := []struct {
+		dest    string
+		level   string
+		reasons []string
+	}{
+		{"https://example.com/path?q=1", verdictOK, []string{}},
+		{"https://example.com:443/", verdictOK, []string{}},
+		{"http://example.com/", verdictWarning, []string{"not_https"}},
+		{"https://192.0.2.1/", verdictWarning, []string{"ip_address_host"}},
+		{"https://[2001:db8::1]:8443/", verdictWarning, []string{"ip_address_host", "non_standard_port"}},
+		{"https://xn--pypal-4ve.com/", verdictWarning, []string{"internationalized_domain"}},
+		{"https://www.xn--pypal-4ve.com/", verdictWarning, []string{"internationalized_domain"}},
+		{"https://pаypal.com/", verdictWarning, []string{"internationalized_domain"}},
+		{"http://", verdictWarning, []string{"unparsable"}},
+	}
+	for _, tc := range cases {
+		v := assessDestination(tc.dest)
+		if v.Level != tc.level || !reflect.DeepEqual(v.Reasons, tc.reasons) {
+			t.Errorf("%s: expected %s %v, got %s %v", tc.dest, tc.level, tc.reasons, v.Level, v.Reasons)
+		}
+	}
+}
+
+func TestLinkPreview(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	links := map[string]resolveResp{
+		"abc":  {LongURL: "http://example.com/landing", CreatedAt: &created},
+		"once": {LongURL: "https://example.com/ticket", MaxUses: 1},
+		"rot":  {LongURL: "https://example.com/", Destinations: []weightedDest{{Variant: "a", URL: "https://example.com/a", Weight: 1}}},
+		"lock": {LongURL: "https://example.com/secret", PasswordHash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+	}
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr, ok := links[code]
+		if !ok {
+			return resolveResp{}, errNotFound
+		}
+		rr.Code = code
+		return rr, nil
+	})
+	h, sink := newTestRedirectHandler(resolver)
+	h.uses = &memoryUsageCounter{}
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/r/abc+?utm_source=x", "application/json")
+	if rr.Code != http.StatusOK || rr.Header().Get("Location") != "" {
+		t.Fatalf("expected a 200 preview, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected previews not to be cached, got %q", cc)
+	}
+	var p linkPreview
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "abc" || p.Destination != "http://example.com/landing" || p.CreatedAt == nil || !p.CreatedAt.Equal(created) ||
+		p.Verdict == nil || p.Verdict.Level != verdictWarning || p.ContinueURL != "/r/abc?utm_source=x" {
+		t.Fatalf("unexpected preview %+v", p)
+	}
+	if evt := <-sink.ch; evt.Type != eventPreview || evt.Code != "abc" {
+		t.Fatalf("expected a preview event, got %+v", evt)
+	}
+
+	rr = get("/r/abc+", "text/html")
+	body := rr.Body.String()
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "<code>http://example.com/landing</code>") ||
+		!strings.Contains(body, "Created 1 March 2024") ||
+		!strings.Contains(body, verdictReasons["not_https"]) ||
+		!strings.Contains(body, `<a href="/r/abc" rel="noreferrer">Continue</a>`) {
+		t.Fatalf("unexpected preview page:\n%s", body)
+	}
+	<-sink.ch
+
+	// Rotated links preview the visitor's variant without assigning it.
+	rr = get("/r/rot+", "application/json")
+	p = linkPreview{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Destination != "https://example.com/a" || !p.Varies || len(rr.Result().Cookies()) != 0 {
+		t.Fatalf("unexpected rotated preview %+v", p)
+	}
+	<-sink.ch
+
+	// Protected links don't disclose their destination.
+	rr = get("/r/lock+", "application/json")
+	p = linkPreview{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &p)
+	if !p.Protected || p.Destination != "" || p.Verdict != nil || strings.Contains(rr.Body.String(), "secret") {
+		t.Fatalf("expected protected preview without destination, got %s", rr.Body.String())
+	}
+	<-sink.ch
+
+	// Neither do click-limited links, and a preview doesn't use them up.
+	rr = get("/r/once+", "application/json")
+	p = linkPreview{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &p)
+	if !p.Limited || p.Destination != "" || p.Verdict != nil || strings.Contains(rr.Body.String(), "ticket") {
+		t.Fatalf("expected limited preview without destination, got %s", rr.Body.String())
+	}
+	if n, _ := h.uses.Remaining(context.Background(), "", "once", 1); n != 1 {
+		t.Fatalf("expected the use to remain after a preview, got %d", n)
+	}
+	if rr := get("/r/once+", "text/html"); strings.Contains(rr.Body.String(), "ticket") {
+		t.Fatalf("expected the page not to show the destination, got %s", rr.Body.String())
+	}
+	<-sink.ch
+	<-sink.ch
+
+	if rr := get("/r/missing+", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown code, got %d", rr.Code)
+	}
+	if rr := get("/r/+", ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an empty code, got %d", rr.Code)
+	}
+	// An encoded '+' is part of the code, not the preview marker.
+	if rr := get("/r/abc%2B", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a lookup of the unknown code abc+, got %d", rr.Code)
+	}
+}
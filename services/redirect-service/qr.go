@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR rendering limits and defaults. size is in pixels, margin in modules;
+// the QR spec asks for a 4-module quiet zone.
+const (
+	qrDefaultSize   = 256
+	qrMinSize       = 64
+	qrMaxSize       = 2048
+	qrDefaultMargin = 4
+	qrMaxMargin     = 16
+
+	// QR images depend only on the short URL and rendering options, so
+	// they can be cached for a long time. A day bounds how long a deleted
+	// code keeps being served from caches.
+	qrCacheControl = "public, max-age=86400"
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// qrOptions are the rendering options taken from the query string.
+type qrOptions struct {
+	Format string // "png" or "svg"
+	Size   int
+	Level  string // L, M, Q or H
+	Margin int
+}
+
+func parseQROptions(q url.Values) (qrOptions, error) {
+	o := qrOptions{Format: "png", Size: qrDefaultSize, Level: "M", Margin: qrDefaultMargin}
+	if v := q.Get("format"); v != "" {
+		if v != "png" && v != "svg" {
+			return o, fmt.Errorf("format must be png or svg")
+		}
+		o.Format = v
+	}
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			return o, fmt.Errorf("size must be between %d and %d", qrMinSize, qrMaxSize)
+		}
+		o.Size = n
+	}
+	if v := q.Get("ec"); v != "" {
+		v = strings.ToUpper(v)
+		if _, ok := qrLevels[v]; !ok {
+			return o, fmt.Errorf("ec must be L, M, Q or H")
+		}
+		o.Level = v
+	}
+	if v := q.Get("margin"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > qrMaxMargin {
+			return o, fmt.Errorf("margin must be between 0 and %d", qrMaxMargin)
+		}
+		o.Margin = n
+	}
+	return o, nil
+}
+
+// qrHandler serves /qr/{code}: a QR code for the public short URL of an
+// existing code. With TENANTS set, the short URL is on the request's host,
+// with PUBLIC_BASE_URL's scheme.
+type qrHandler struct {
+	redirect      *redirectHandler // shares its resolver and clock
+	baseURL       string           // PUBLIC_BASE_URL, without a trailing slash
+	rootPathLinks bool             // encode /{code} rather than /r/{code}
+	logf          func(level, msg string, fields map[string]interface{})
+}
+
+func (h *qrHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/qr/"))
+	if code == "" || len(code) > 64 || strings.Contains(code, "/") {
+		http.Error(w, "invalid_code", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseQROptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant, ok := h.redirect.tenant(w, r)
+	if !ok {
+		return
+	}
+
+	rr, ok := h.redirect.resolve(w, r, tenant, code)
+	if !ok {
+		return
+	}
+	// Scheduled links stay undiscoverable here too.
+	if rr.windowAt(h.redirect.clock()) == windowPending {
+		http.Error(w, "not_found", http.StatusNotFound)
+		return
+	}
+
+	base := h.baseURL
+	if h.redirect.tenants != nil {
+		base = tenantBaseURL(base, r.Host)
+	}
+	target := base + "/r/" + url.PathEscape(code)
+	if h.rootPathLinks && isRootLinkPath("/"+url.PathEscape(code)) {
+		// Shorter URLs make smaller, easier to scan symbols.
+		target = base + "/" + url.PathEscape(code)
+	}
+	// The output is a pure function of these inputs, so their hash is a
+	// strong validator.
+	sum := sha256.Sum256([]byte(fmt.Sprintf("v1|%s|%s|%d|%s|%d", target, opts.Format, opts.Size, opts.Level, opts.Margin)))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", qrCacheControl)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	q, err := qrcode.New(target, qrLevels[opts.Level])
+	if err != nil {
+		h.logf("error", "qr encode failed", map[string]interface{}{
+			"code":       code,
+			"err":        err.Error(),
+			"request_id": requestIDFromContext(r.Context()),
+		})
+		http.Error(w, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	q.DisableBorder = true
+	bitmap := q.Bitmap()
+
+	var body []byte
+	if opts.Format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		body = renderQRSVG(bitmap, opts.Size, opts.Margin)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		var buf bytes.Buffer
+		_ = png.Encode(&buf, renderQRImage(bitmap, opts.Size, opts.Margin))
+		body = buf.Bytes()
+	}
+	qrCodesTotal.WithLabelValues(opts.Format).Inc()
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
+
+// tenantBaseURL swaps the host of base for the request's (already allowed)
+// host, keeping base's scheme, port and path.
+func tenantBaseURL(base, host string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(normalizeHost(host), port)
+	} else {
+		u.Host = normalizeHost(host)
+	}
+	return u.String()
+}
+
+// etagMatches implements the If-None-Match comparison for our strong ETags.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// qrLayout fits a symbol of n modules plus margin on each side into size
+// pixels. Modules are whole pixels so edges stay sharp; leftover pixels
+// widen the margin evenly. Tiny sizes grow to one pixel per module.
+func qrLayout(n, size, margin int) (scale, offset, side int) {
+	total := n + 2*margin
+	scale = max(size/total, 1)
+	side = max(size, scale*total)
+	return scale, (side - scale*n) / 2, side
+}
+
+func renderQRImage(bitmap [][]bool, size, margin int) image.Image {
+	scale, offset, side := qrLayout(len(bitmap), size, margin)
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// renderQRSVG draws the symbol in module units, one path with a
+// rectangle per horizontal run of dark modules, scaled to size pixels.
+func renderQRSVG(bitmap [][]bool, size, margin int) []byte {
+	n := len(bitmap)
+	total := n + 2*margin
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, total, total)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+	for y, row := range bitmap {
+		for x := 0; x < n; {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", margin+start, margin+y, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqr "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+func newTestQRHandler() *qrHandler {
+	h, _ := newTestRedirectHandler(staticResolver(map[string]string{"abc": "https://example.com"}))
+	return &qrHandler{redirect: h, baseURL: "https://s.example", logf: h.logf}
+}
+
+// decodeQR reads a QR code back out of img with gozxing, a decoder
+// independent of the encoder used by the handler, and returns its text and
+// error correction level.
+func decodeQR(t *testing.T, img image.Image) (text, level string) {
+	t.Helper()
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := zxingqr.NewQRCodeReader().Decode(bmp, map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_PURE_BARCODE: true,
+	})
+	if err != nil {
+		t.Fatalf("QR code does not decode: %v", err)
+	}
+	level, _ = res.GetResultMetadata()[gozxing.ResultMetadataType_ERROR_CORRECTION_LEVEL].(string)
+	return res.GetText(), level
+}
+
+func TestQRCodePNG(t *testing.T) {
+	h := newTestQRHandler()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/qr/abc?size=300&ec=h&margin=2", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("expected a PNG, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 300 {
+		t.Fatalf("expected 300x300, got %v", b)
+	}
+	if text, level := decodeQR(t, img); text != "https://s.example/r/abc" || level != "H" {
+		t.Fatalf("expected the short URL at level H, got %q at %q", text, level)
+	}
+
+	// The margin is blank and at least two modules wide.
+	dark := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r < 0x8000
+	}
+	for i := 0; i < 300; i++ {
+		if dark(i, 0) || dark(0, i) || dark(i, 299) || dark(299, i) {
+			t.Fatal("expected a blank quiet zone")
+		}
+	}
+	// The top-left finder pattern's outer ring is 7 modules wide, which
+	// gives the module size.
+	first := 0
+	for !dark(first, first) {
+		first++
+	}
+	run := 0
+	for dark(first+run, first) {
+		run++
+	}
+	if scale := run / 7; first < 2*scale {
+		t.Fatalf("expected at least a 2-module margin, got %dpx at %dpx per module", first, scale)
+	}
+}
+
+func TestQRCodeSVG(t *testing.T) {
+	h := newTestQRHandler()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/qr/abc?format=svg&size=512", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("expected an SVG, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), `width="512" height="512"`) {
+		t.Fatalf("unexpected svg header: %.200s", rr.Body.String())
+	}
+
+	// Rasterise the SVG and decode the pixels.
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon.SetTarget(0, 0, 512, 512)
+	img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	scanner := rasterx.NewScannerGV(512, 512, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(512, 512, scanner), 1)
+	if text, level := decodeQR(t, img); text != "https://s.example/r/abc" || level != "M" {
+		t.Fatalf("expected the short URL at level M, got %q at %q", text, level)
+	}
+}
+
+func TestQRCodeCaching(t *testing.T) {
+	h := newTestQRHandler()
+	get := func(target, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := get("/qr/abc", "")
+	etag := first.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || first.Header().Get("Cache-Control") != qrCacheControl {
+		t.Fatalf("expected a strong ETag and Cache-Control, got %q %q", etag, first.Header().Get("Cache-Control"))
+	}
+	second := get("/qr/abc", "")
+	if second.Header().Get("ETag") != etag || !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Fatal("expected identical output and ETag for identical requests")
+	}
+	if rr := get("/qr/abc", etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("expected 304 for a matching If-None-Match, got %d", rr.Code)
+	}
+	if rr := get("/qr/abc?size=512", etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatal("expected other options to produce another ETag")
+	}
+}
+
+func TestQRCodeErrors(t *testing.T) {
+	h := newTestQRHandler()
+	cases := map[string]int{
+		"/qr/missing":           http.StatusNotFound,
+		"/qr/":                  http.StatusBadRequest,
+		"/qr/abc?size=10":       http.StatusBadRequest,
+		"/qr/abc?size=big":      http.StatusBadRequest,
+		"/qr/abc?ec=X":          http.StatusBadRequest,
+		"/qr/abc?margin=-1":     http.StatusBadRequest,
+		"/qr/abc?format=gif":    http.StatusBadRequest,
+		"/qr/abc?ec=q&margin=0": http.StatusOK,
+	}
+	for target, want := range cases {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		if rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rr.Code)
+		}
+	}
+}
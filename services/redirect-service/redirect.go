@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redirectHandler serves /r/{code} and /r/{code}/{suffix}, and /{code} in
+// root-path mode (see rootHandler): it resolves the code through the
+// configured Resolver, in the namespace of the request's host, enqueues a
+// best-effort analytics event, and issues the redirect with the link's
+// status (or defaultStatus).
+type redirectHandler struct {
+	resolver Resolver
+	sink     *analyticsSink
+	uses     usageCounter // nil unless USAGE_DATABASE_URL is set
+	gate     *passwordGate
+	geo      *geoIP // nil unless GEOIP_DATABASE is set
+	logf     func(level, msg string, fields map[string]interface{})
+
+	defaultStatus   int
+	permanentMaxAge time.Duration
+	trustProxy      bool
+	// Defaults for links that don't set their own passthrough options.
+	queryPassthrough string
+	pathPassthrough  bool
+	// Service-wide parameter templates, and the default conflict rule.
+	injectParams   map[string]string
+	injectConflict string
+	tenants        tenantMap  // nil unless TENANTS is set
+	blocklist      *blocklist // nil unless BLOCKLIST_FILE is set
+	blockAction    string
+
+	now func() time.Time // overridable in tests; nil means time.Now
+}
+
+func (h *redirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// POST is only accepted by 307/308 links, which preserve the method,
+	// and as the password form submission for protected links; that is
+	// checked once the link is known.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := requestIDFromContext(r.Context())
+	tenant, ok := h.tenant(w, r)
+	if !ok {
+		return
+	}
+
+	code, suffix, preview, err := splitRedirectPath(r.URL.EscapedPath())
+	if errors.Is(err, errDotSegment) {
+		http.Error(w, "invalid_path", http.StatusBadRequest)
+		return
+	}
+	if preview && err == nil {
+		h.servePreview(w, r, tenant, code)
+		return
+	}
+	if err != nil || code == "" || len(code) > 64 {
+		http.Error(w, "invalid_code", http.StatusBadRequest)
+		return
+	}
+
+	rr, ok := h.resolve(w, r, tenant, code)
+	if !ok {
+		return
+	}
+	pathPassthrough := h.pathPassthrough
+	if rr.PathPassthrough != nil {
+		pathPassthrough = *rr.PathPassthrough
+	}
+	if suffix != "" && !pathPassthrough {
+		// Without passthrough a suffix is part of no known code.
+		http.Error(w, "not_found", http.StatusNotFound)
+		return
+	}
+	vis := visitorFromRequest(r, h.geo, h.trustProxy)
+	dest, variant := rr.target(vis)
+	assignVariant := false
+	if variant == defaultVariant && len(rr.Destinations) > 0 {
+		d, assigned := pickDestination(r, code, rr.Destinations, h.trustProxy)
+		dest, variant, assignVariant = d.URL, d.Variant, assigned
+	}
+
+	status := rr.RedirectStatus
+	if status == 0 {
+		status = h.defaultStatus
+	}
+
+	// The activation window is checked on every request, including cache
+	// and stale hits, so a cached record is never served outside it.
+	now := h.clock()
+	expired := false
+	switch rr.windowAt(now) {
+	case windowPending:
+		// Indistinguishable from an unknown code, so scheduled links can't
+		// be discovered early.
+		http.Error(w, "not_found", http.StatusNotFound)
+		return
+	case windowExpired:
+		expired = true
+	}
+
+	// Protected links redirect only after the password gate. A POST here is
+	// the form submission, so it is answered with 303 to make the browser
+	// follow with a GET.
+	if !expired && rr.PasswordHash != "" {
+		if !h.gate.Allow(w, r, rr) {
+			return
+		}
+		if r.Method == http.MethodPost {
+			status = http.StatusSeeOther
+		}
+	}
+
+	// The forwarded path and query, then injected parameters, are added
+	// per click; the stored destination is never rewritten. The result is
+	// what the blocklist is checked against.
+	queryMode := rr.QueryPassthrough
+	if queryMode == "" {
+		queryMode = h.queryPassthrough
+	}
+	conflict := rr.InjectConflict
+	if conflict == "" {
+		conflict = h.injectConflict
+	}
+	finish := func(dest, variant string) (string, bool) {
+		dest, err := applyPassthrough(dest, suffix, r.URL.RawQuery, queryMode)
+		if err == nil {
+			dest, err = injectParams(dest, injectedQuery(h.injectParams, rr.InjectParams, clickVars{
+				Code:      code,
+				RequestID: rid,
+				Country:   vis.Country,
+				Variant:   variant,
+			}), conflict)
+		}
+		if err != nil {
+			h.logf("error", "invalid destination", map[string]interface{}{
+				"code":       code,
+				"err":        err.Error(),
+				"request_id": rid,
+			})
+			http.Error(w, "bad_gateway", http.StatusBadGateway)
+			return "", false
+		}
+		if h.blocklist != nil {
+			if m, blocked := h.blocklist.Match(dest); blocked {
+				h.serveBlocked(w, r, tenant, code, dest, m)
+				return "", false
+			}
+		}
+		return dest, true
+	}
+
+	// Uses are only consumed for a request that would otherwise redirect,
+	// so a POST to a non-307/308 link, a broken destination and a blocked
+	// one are all rejected first.
+	if !expired && r.Method == http.MethodPost && !allowsPost(status) {
+		http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !expired {
+		if dest, ok = finish(dest, variant); !ok {
+			return
+		}
+	}
+	exhausted := false
+	usesLeft := -1
+	if !expired && rr.MaxUses > 0 {
+		n, err := h.consumeUse(r, rr)
+		if errors.Is(err, errUsesExhausted) {
+			exhausted = true
+		} else if err != nil {
+			// Fail closed: an unenforced limit is worse than a failed click.
+			h.logf("error", "usage check failed", map[string]interface{}{
+				"code":       code,
+				"err":        err.Error(),
+				"request_id": rid,
+			})
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "service_unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		usesLeft = n
+	}
+
+	if expired || exhausted {
+		if rr.FallbackURL == "" {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		dest, variant, status = rr.FallbackURL, fallbackVariant, http.StatusFound
+	}
+	if r.Method == http.MethodPost && !allowsPost(status) {
+		http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if expired || exhausted {
+		if dest, ok = finish(dest, variant); !ok {
+			return
+		}
+	}
+
+	// Emit analytics event asynchronously (best-effort).
+	ref := strings.TrimSpace(r.Referer())
+	evt := analyticsEvent{
+		Code:      code,
+		Tenant:    tenant,
+		TS:        time.Now().Unix(),
+		UserAgent: r.UserAgent(),
+		RequestID: rid,
+		Type:      eventClick,
+		Variant:   variant,
+		Country:   vis.Country,
+	}
+	if isHTTPURL(ref) {
+		evt.Referrer = ref
+	}
+	if ok := h.sink.Enqueue(evt); !ok {
+		h.logf("error", "analytics queue full (event dropped)", map[string]interface{}{
+			"code":       code,
+			"request_id": rid,
+		})
+	}
+
+	h.logf("info", "redirect", map[string]interface{}{
+		"tenant":     tenant,
+		"code":       code,
+		"to":         dest,
+		"variant":    variant,
+		"country":    vis.Country,
+		"ua":         r.UserAgent(),
+		"status":     status,
+		"stale":      rr.Stale,
+		"expired":    expired,
+		"exhausted":  exhausted,
+		"uses_left":  usesLeft,
+		"request_id": rid,
+	})
+
+	if len(rr.Rules) > 0 {
+		w.Header().Set("Vary", "User-Agent, Accept-Language")
+	}
+	if assignVariant && variant != fallbackVariant {
+		setVariantCookie(w, r, code, variant)
+	}
+	w.Header().Set("Cache-Control", h.cacheControl(status, rr, now))
+	redirectsTotal.WithLabelValues(strconv.Itoa(status)).Inc()
+	http.Redirect(w, r, dest, status)
+}
+
+// resolve looks up code in tenant's namespace, answering the request itself
+// when that fails: 404 for unknown codes, 502 for backend errors.
+func (h *redirectHandler) resolve(w http.ResponseWriter, r *http.Request, tenant, code string) (resolveResp, bool) {
+	rr, err := h.resolver.Resolve(r.Context(), tenant, code)
+	if errors.Is(err, errNotFound) {
+		http.Error(w, "not_found", http.StatusNotFound)
+		return resolveResp{}, false
+	}
+	if err != nil {
+		h.logf("error", "resolve failed", map[string]interface{}{
+			"tenant":     tenant,
+			"code":       code,
+			"status":     upstreamStatus(err),
+			"err":        err.Error(),
+			"request_id": requestIDFromContext(r.Context()),
+		})
+		http.Error(w, "bad_gateway", http.StatusBadGateway)
+		return resolveResp{}, false
+	}
+	return rr, true
+}
+
+// clock returns h.now() if set, else the current time.
+func (h *redirectHandler) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// cacheControl lets clients and CDNs cache permanent redirects for
+// permanentMaxAge, capped at the link's expiry. Temporary redirects are
+// never cached, so every click reaches us and is counted. A permanent
+// redirect served from a stale cache entry is not cached either: the link
+// may have changed since. Neither is one for a click-limited link, whose
+// every use must reach the usage counter, nor for a protected link, which a
+// shared cache would otherwise hand out without the password. Injected
+// parameters that differ per click ({request_id}) rule out caching too.
+func (h *redirectHandler) cacheControl(status int, rr resolveResp, now time.Time) string {
+	if !isPermanentRedirect(status) || rr.Stale || rr.MaxUses > 0 || rr.PasswordHash != "" ||
+		usesPlaceholder(h.injectParams, rr.InjectParams, placeholderRequestID) {
+		return "no-store"
+	}
+	maxAge := h.permanentMaxAge
+	if rr.ExpiresAt != nil {
+		maxAge = min(maxAge, rr.ExpiresAt.Sub(now))
+	}
+	secs := int(maxAge / time.Second)
+	if secs <= 0 {
+		return "no-store"
+	}
+	// Targeted and rotated links, and those injecting the visitor's
+	// country, differ per visitor; only the visitor's own browser may keep
+	// them.
+	if len(rr.Rules) > 0 || len(rr.Destinations) > 0 ||
+		usesPlaceholder(h.injectParams, rr.InjectParams, placeholderCountry) {
+		return "private, max-age=" + strconv.Itoa(secs)
+	}
+	return "public, max-age=" + strconv.Itoa(secs)
+}
+
+// consumeUse takes one of the link's max_uses. HEAD requests only check
+// that a use is left, so link checkers and crawlers don't burn them.
+func (h *redirectHandler) consumeUse(r *http.Request, rr resolveResp) (int, error) {
+	if h.uses == nil {
+		linkUsesTotal.WithLabelValues("error").Inc()
+		return 0, errors.New("max_uses is set but USAGE_DATABASE_URL is not configured")
+	}
+	var (
+		n   int
+		err error
+	)
+	if r.Method == http.MethodHead {
+		n, err = h.uses.Remaining(r.Context(), rr.Namespace, rr.Code, rr.MaxUses)
+		if err == nil && n == 0 {
+			err = errUsesExhausted
+		}
+	} else {
+		n, err = h.uses.Consume(r.Context(), rr.Namespace, rr.Code, rr.MaxUses)
+	}
+	switch {
+	case errors.Is(err, errUsesExhausted):
+		linkUsesTotal.WithLabelValues("exhausted").Inc()
+	case err != nil:
+		linkUsesTotal.WithLabelValues("error").Inc()
+	case r.Method != http.MethodHead:
+		linkUsesTotal.WithLabelValues("consumed").Inc()
+	}
+	return n, err
+}
+
+func isPermanentRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+}
+
+// allowsPost reports whether a POST may be answered with status: either it
+// is forwarded as a POST, or it is a password form answered with 303.
+func allowsPost(status int) bool {
+	return preservesMethod(status) || status == http.StatusSeeOther
+}
+
+// preservesMethod reports whether clients repeat the original method and
+// body when following a redirect with status.
+func preservesMethod(status int) bool {
+	return status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestRedirectHandler(resolver Resolver) (*redirectHandler, *analyticsSink) {
+	logf := func(string, string, map[string]interface{}) {}
+	sink := newAnalyticsSink(Config{AnalyticsQueueLen: 16}, logf, nil)
+	return &redirectHandler{
+		resolver: resolver,
+		sink:     sink,
+		logf:     logf,
+		gate: newPasswordGate(Config{
+			UnlockCookieSecret:    []byte("test-secret"),
+			UnlockCookieTTL:       15 * time.Minute,
+			UnlockCookieSecure:    true,
+			PasswordMaxFailures:   3,
+			PasswordFailureWindow: time.Minute,
+		}, logf),
+		defaultStatus:   http.StatusFound,
+		permanentMaxAge: time.Hour,
+	}, sink
+}
+
+func staticResolver(links map[string]string) Resolver {
+	return resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		dest, ok := links[code]
+		if !ok {
+			return resolveResp{}, errNotFound
+		}
+		return resolveResp{Code: code, LongURL: dest}, nil
+	})
+}
+
+func TestRedirectHandlerRedirects(t *testing.T) {
+	h, sink := newTestRedirectHandler(staticResolver(map[string]string{"abc": "https://example.com"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/r/abc", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", rr.Code)
+	}
+	if loc := rr.Header().Get("Location"); loc != "https://example.com" {
+		t.Fatalf("expected Location https://example.com, got %q", loc)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected Cache-Control no-store, got %q", cc)
+	}
+	select {
+	case evt := <-sink.ch:
+		if evt.Code != "abc" || evt.UserAgent != "test-agent" {
+			t.Fatalf("unexpected analytics event: %+v", evt)
+		}
+	default:
+		t.Fatal("expected an analytics event to be enqueued")
+	}
+}
+
+func TestRedirectHandlerErrors(t *testing.T) {
+	failing := resolverFunc(func(context.Context, string, string) (resolveResp, error) {
+		return resolveResp{}, errors.New("connection refused")
+	})
+
+	cases := []struct {
+		name     string
+		resolver Resolver
+		method   string
+		path     string
+		want     int
+	}{
+		{"not found", staticResolver(nil), http.MethodGet, "/r/nope", http.StatusNotFound},
+		{"upstream error", failing, http.MethodGet, "/r/abc", http.StatusBadGateway},
+		{"empty code", staticResolver(nil), http.MethodGet, "/r/", http.StatusBadRequest},
+		{"method", staticResolver(nil), http.MethodDelete, "/r/abc", http.StatusMethodNotAllowed},
+		{"post to 302 link", staticResolver(map[string]string{"abc": "https://example.com"}), http.MethodPost, "/r/abc", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, sink := newTestRedirectHandler(tc.resolver)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rr.Code)
+			}
+			if len(sink.ch) != 0 {
+				t.Fatal("expected no analytics event")
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerStatus(t *testing.T) {
+	links := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr := resolveResp{Code: code, LongURL: "https://example.com/" + code}
+		switch code {
+		case "perm":
+			rr.RedirectStatus = http.StatusMovedPermanently
+		case "post":
+			rr.RedirectStatus = http.StatusTemporaryRedirect
+		case "stale":
+			rr.RedirectStatus, rr.Stale = http.StatusPermanentRedirect, true
+		}
+		return rr, nil
+	})
+
+	cases := []struct {
+		name          string
+		method        string
+		code          string
+		defaultStatus int
+		wantStatus    int
+		wantCache     string
+	}{
+		{"default", http.MethodGet, "plain", http.StatusFound, http.StatusFound, "no-store"},
+		{"configured default", http.MethodGet, "plain", http.StatusPermanentRedirect, http.StatusPermanentRedirect, "public, max-age=3600"},
+		{"per-link permanent", http.MethodGet, "perm", http.StatusFound, http.StatusMovedPermanently, "public, max-age=3600"},
+		{"per-link 307 accepts post", http.MethodPost, "post", http.StatusFound, http.StatusTemporaryRedirect, "no-store"},
+		{"stale permanent not cached", http.MethodGet, "stale", http.StatusFound, http.StatusPermanentRedirect, "no-store"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := newTestRedirectHandler(links)
+			h.defaultStatus = tc.defaultStatus
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tc.method, "/r/"+tc.code, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("expected %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if cc := rr.Header().Get("Cache-Control"); cc != tc.wantCache {
+				t.Fatalf("expected Cache-Control %q, got %q", tc.wantCache, cc)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerActivationWindow(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	links := map[string]resolveResp{
+		"pending":  {LongURL: "https://example.com/p", NotBefore: at(time.Minute)},
+		"live":     {LongURL: "https://example.com/l", NotBefore: at(-time.Minute), ExpiresAt: at(10 * time.Minute), RedirectStatus: http.StatusMovedPermanently},
+		"expired":  {LongURL: "https://example.com/e", ExpiresAt: at(0)},
+		"fallback": {LongURL: "https://example.com/f", ExpiresAt: at(-time.Hour), FallbackURL: "https://example.com/over", RedirectStatus: http.StatusMovedPermanently},
+	}
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr, ok := links[code]
+		if !ok {
+			return resolveResp{}, errNotFound
+		}
+		rr.Code = code
+		return rr, nil
+	})
+
+	cases := []struct {
+		code      string
+		want      int
+		wantLoc   string
+		wantCache string
+		wantEvent bool
+	}{
+		{"pending", http.StatusNotFound, "", "", false},
+		// max-age is capped at the 10 minutes left before expiry.
+		{"live", http.StatusMovedPermanently, "https://example.com/l", "public, max-age=600", true},
+		{"expired", http.StatusGone, "", "no-store", false},
+		{"fallback", http.StatusFound, "https://example.com/over", "no-store", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			h, sink := newTestRedirectHandler(resolver)
+			h.now = func() time.Time { return now }
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/r/"+tc.code, nil))
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rr.Code)
+			}
+			if loc := rr.Header().Get("Location"); loc != tc.wantLoc {
+				t.Fatalf("expected Location %q, got %q", tc.wantLoc, loc)
+			}
+			if cc := rr.Header().Get("Cache-Control"); cc != tc.wantCache {
+				t.Fatalf("expected Cache-Control %q, got %q", tc.wantCache, cc)
+			}
+			if got := len(sink.ch) == 1; got != tc.wantEvent {
+				t.Fatalf("expected analytics event %v, got %v", tc.wantEvent, got)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerMaxUses(t *testing.T) {
+	links := map[string]resolveResp{
+		"once":     {LongURL: "https://example.com/once", MaxUses: 1, RedirectStatus: http.StatusMovedPermanently},
+		"fallback": {LongURL: "https://example.com/f", MaxUses: 1, FallbackURL: "https://example.com/over"},
+	}
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		rr, ok := links[code]
+		if !ok {
+			return resolveResp{}, errNotFound
+		}
+		rr.Code = code
+		return rr, nil
+	})
+	h, sink := newTestRedirectHandler(resolver)
+	h.uses = &memoryUsageCounter{used: map[string]int{}}
+
+	serve := func(method, code string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, "/r/"+code, nil))
+		return rr
+	}
+
+	// HEAD only checks, so it doesn't use up a one-time link.
+	for range 3 {
+		if rr := serve(http.MethodHead, "once"); rr.Code != http.StatusMovedPermanently {
+			t.Fatalf("expected HEAD to redirect, got %d", rr.Code)
+		}
+	}
+	rr := serve(http.MethodGet, "once")
+	if rr.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected first GET to redirect, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected limited link not to be cacheable, got %q", cc)
+	}
+	if rr := serve(http.MethodGet, "once"); rr.Code != http.StatusGone {
+		t.Fatalf("expected 410 once used up, got %d", rr.Code)
+	}
+	if rr := serve(http.MethodHead, "once"); rr.Code != http.StatusGone {
+		t.Fatalf("expected HEAD 410 once used up, got %d", rr.Code)
+	}
+
+	serve(http.MethodGet, "fallback")
+	rr = serve(http.MethodGet, "fallback")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/over" {
+		t.Fatalf("expected redirect to fallback once used up, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// One event per redirect: 3 HEAD + 1 GET on "once", 2 GET on "fallback".
+	if got := len(sink.ch); got != 6 {
+		t.Fatalf("expected 6 analytics events, got %d", got)
+	}
+}
+
+func TestRedirectHandlerMaxUsesFailsClosed(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		return resolveResp{Code: code, LongURL: "https://example.com", MaxUses: 3}, nil
+	})
+
+	for name, uses := range map[string]usageCounter{
+		"not configured": nil,
+		"store error":    &memoryUsageCounter{err: errors.New("connection refused")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, sink := newTestRedirectHandler(resolver)
+			h.uses = uses
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/r/abc", nil))
+			if rr.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503, got %d", rr.Code)
+			}
+			if len(sink.ch) != 0 {
+				t.Fatal("expected no analytics event")
+			}
+		})
+	}
+}
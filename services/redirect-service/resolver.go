@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errNotFound is returned by a Resolver when the code does not exist.
+var errNotFound = errors.New("code not found")
+
+// Resolver looks up the destination for a short code in a tenant's
+// namespace ("" is the default one; see tenantMap). Implementations must
+// return errNotFound for unknown codes and must only return records that
+// pass resolveResp.validate. The request ID, when present, is carried on
+// ctx (see requestIDFromContext).
+type Resolver interface {
+	Resolve(ctx context.Context, tenant, code string) (resolveResp, error)
+}
+
+// resolveResp is the resolve contract shared by every Resolver. It matches
+// the JSON body of url-service's GET /urls/{code}.
+type resolveResp struct {
+	Code    string `json:"code"`
+	LongURL string `json:"long_url"`
+
+	// Namespace is the tenant namespace the code belongs to; empty is the
+	// default namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// CreatedAt is when the link was created. It is only shown on the
+	// preview page and may be unset.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	// RedirectStatus is the HTTP status to redirect with (301, 302, 307 or
+	// 308). Zero means the service-wide DEFAULT_REDIRECT_STATUS.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+
+	// NotBefore and ExpiresAt bound when the link redirects. Before
+	// NotBefore it is treated as not found; from ExpiresAt on it is gone,
+	// or redirects to FallbackURL if one is set.
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+
+	// MaxUses limits how many times the link redirects across all
+	// replicas; once used up it behaves as expired. Zero means unlimited.
+	MaxUses int `json:"max_uses,omitempty"`
+
+	// PasswordHash, when set, is a bcrypt or scrypt hash of the password
+	// visitors must enter before the link redirects (see comparePassword).
+	// Protected is url-service's public flag for such links; a protected
+	// record without the hash is rejected rather than redirected.
+	PasswordHash string `json:"password_hash,omitempty"`
+	Protected    bool   `json:"protected,omitempty"`
+
+	// Rules route visitors to other destinations by platform or language;
+	// LongURL is used when none matches. See targetRule.
+	Rules []targetRule `json:"rules,omitempty"`
+
+	// Destinations split visitors that no rule matched across several URLs
+	// by weight, in place of LongURL. See pickDestination.
+	Destinations []weightedDest `json:"destinations,omitempty"`
+
+	// QueryPassthrough and PathPassthrough forward the short link's query
+	// string and any path after the code to the destination. Unset means
+	// the service-wide QUERY_PASSTHROUGH and PATH_PASSTHROUGH.
+	QueryPassthrough string `json:"query_passthrough,omitempty"`
+	PathPassthrough  *bool  `json:"path_passthrough,omitempty"`
+
+	// InjectParams are parameter templates added to the destination query,
+	// on top of the service-wide INJECT_PARAMS; see injectedQuery.
+	// InjectConflict overrides INJECT_CONFLICT for this link.
+	InjectParams   map[string]string `json:"inject_params,omitempty"`
+	InjectConflict string            `json:"inject_conflict,omitempty"`
+
+	// Stale is set by cachingResolver when the record was served past its
+	// cache TTL. It is never part of the wire format.
+	Stale bool `json:"-"`
+}
+
+// validate applies the destination checks every backend must enforce
+// before a record can be used for a redirect, and rewrites every URL in the
+// record to its canonical form (see normalizeDestination).
+func (rr *resolveResp) validate() error {
+	// url-service's public record for a protected link has neither the
+	// hash nor a destination.
+	if rr.PasswordHash != "" {
+		if err := checkPasswordHash(rr.PasswordHash); err != nil {
+			return fmt.Errorf("invalid password_hash for code %q: %w", rr.Code, err)
+		}
+	} else if rr.Protected {
+		return fmt.Errorf("code %q is protected but has no password_hash", rr.Code)
+	}
+	var err error
+	if rr.LongURL, err = normalizeDestination(rr.LongURL); err != nil {
+		return fmt.Errorf("invalid long_url for code %q: %w", rr.Code, err)
+	}
+	if !isNamespace(rr.Namespace) {
+		return fmt.Errorf("invalid namespace %q for code %q", rr.Namespace, rr.Code)
+	}
+	if rr.RedirectStatus != 0 && !isRedirectStatus(rr.RedirectStatus) {
+		return fmt.Errorf("invalid redirect_status %d for code %q", rr.RedirectStatus, rr.Code)
+	}
+	if rr.FallbackURL != "" {
+		if rr.FallbackURL, err = normalizeDestination(rr.FallbackURL); err != nil {
+			return fmt.Errorf("invalid fallback_url for code %q: %w", rr.Code, err)
+		}
+	}
+	if rr.MaxUses < 0 {
+		return fmt.Errorf("invalid max_uses for code %q", rr.Code)
+	}
+	for i := range rr.Rules {
+		if err := rr.Rules[i].validate(); err != nil {
+			return fmt.Errorf("invalid rule for code %q: %w", rr.Code, err)
+		}
+	}
+	if err := validateDestinations(rr.Destinations); err != nil {
+		return fmt.Errorf("invalid destinations for code %q: %w", rr.Code, err)
+	}
+	if rr.QueryPassthrough != "" && !isQueryPassthroughMode(rr.QueryPassthrough) {
+		return fmt.Errorf("invalid query_passthrough %q for code %q", rr.QueryPassthrough, rr.Code)
+	}
+	if err := validateParamTemplates(rr.InjectParams); err != nil {
+		return fmt.Errorf("invalid inject_params for code %q: %w", rr.Code, err)
+	}
+	if rr.InjectConflict != "" && !isInjectConflict(rr.InjectConflict) {
+		return fmt.Errorf("invalid inject_conflict %q for code %q", rr.InjectConflict, rr.Code)
+	}
+	if rr.NotBefore != nil && rr.ExpiresAt != nil && !rr.ExpiresAt.After(*rr.NotBefore) {
+		return fmt.Errorf("expires_at is not after not_before for code %q", rr.Code)
+	}
+	return nil
+}
+
+// linkWindow is where a point in time falls relative to a link's
+// not_before/expires_at bounds.
+type linkWindow int
+
+const (
+	windowActive linkWindow = iota
+	windowPending
+	windowExpired
+)
+
+// windowAt reports whether the link is pending, active or expired at t.
+// not_before is inclusive and expires_at exclusive.
+func (rr resolveResp) windowAt(t time.Time) linkWindow {
+	switch {
+	case rr.NotBefore != nil && t.Before(*rr.NotBefore):
+		return windowPending
+	case rr.ExpiresAt != nil && !t.Before(*rr.ExpiresAt):
+		return windowExpired
+	}
+	return windowActive
+}
+
+// isRedirectStatus reports whether status is one of the redirect statuses a
+// link may use.
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// Resolver backends selectable with RESOLVER.
+const (
+	resolverHTTP     = "http"
+	resolverPostgres = "postgres"
+	resolverFile     = "file"
+)
+
+// buildResolver constructs the configured backend, wrapped with the
+// snapshot fallback if one is configured. client is only used by the http
+// backend. Snapshot files are watched for changes until ctx is done. The
+// returned close function releases any resources held by the backend.
+func buildResolver(ctx context.Context, cfg Config, client *http.Client, logf func(level, msg string, fields map[string]interface{})) (Resolver, func(), error) {
+	var (
+		primary Resolver
+		closeFn = func() {}
+	)
+	switch cfg.Resolver {
+	case resolverPostgres:
+		r, err := newPostgresResolver(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := prometheus.Register(newPoolStatsCollector(r.pool)); err != nil {
+			r.Close()
+			return nil, nil, err
+		}
+		primary, closeFn = r, r.Close
+	case resolverFile:
+		r, err := newSnapshotResolver(ctx, cfg, cfg.ResolverFile, logf)
+		if err != nil {
+			return nil, nil, err
+		}
+		primary = r
+	default:
+		primary = newHTTPResolver(client, cfg.BaseURL, cfg.URLServiceToken)
+	}
+
+	if cfg.ResolverFallbackFile == "" {
+		return primary, closeFn, nil
+	}
+	fallback, err := newSnapshotResolver(ctx, cfg, cfg.ResolverFallbackFile, logf)
+	if err != nil {
+		closeFn()
+		return nil, nil, err
+	}
+	return &fallbackResolver{primary: primary, fallback: fallback, logf: logf}, closeFn, nil
+}
+
+// newSnapshotResolver loads a snapshot file and starts watching it for
+// changes.
+func newSnapshotResolver(ctx context.Context, cfg Config, path string, logf func(level, msg string, fields map[string]interface{})) (*fileResolver, error) {
+	r, err := newFileResolver(path)
+	if err != nil {
+		return nil, err
+	}
+	go newFileWatcher("snapshot", path, cfg.SnapshotReloadInterval, r.load, logf).Run(ctx)
+	return r, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// fileResolver serves codes from a JSON-lines snapshot file. Each line has
+// the same shape as url-service's resolve response, plus the namespace for
+// codes outside the default one:
+//
+//	{"code":"abc","long_url":"https://example.com"}
+//	{"code":"abc","namespace":"brand-b","long_url":"https://brand-b.example"}
+//
+// The whole file is parsed and validated into a new map before it replaces
+// the current one, so lookups never see a partially loaded snapshot and a
+// bad file never replaces a good one. Reloads are driven by a fileWatcher.
+type fileResolver struct {
+	path  string
+	links atomic.Pointer[map[string]resolveResp]
+}
+
+func newFileResolver(path string) (*fileResolver, error) {
+	if path == "" {
+		return nil, errors.New("snapshot file path is required for the file resolver")
+	}
+	f := &fileResolver{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load parses the snapshot and atomically swaps it in.
+func (f *fileResolver) load() error {
+	links, err := readSnapshot(f.path)
+	if err != nil {
+		return err
+	}
+	f.links.Store(&links)
+	resolverSnapshotEntries.Set(float64(len(links)))
+	return nil
+}
+
+// maxSnapshotLine bounds a single snapshot record. Records with many rules,
+// destinations or injected parameters easily outgrow bufio's 64 KiB default.
+const maxSnapshotLine = 16 << 20
+
+func readSnapshot(path string) (map[string]resolveResp, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	links := map[string]resolveResp{}
+	sc := bufio.NewScanner(fh)
+	sc.Buffer(nil, maxSnapshotLine)
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var rr resolveResp
+		if err := json.Unmarshal(b, &rr); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if rr.Code == "" {
+			return nil, fmt.Errorf("%s:%d: missing code", path, line)
+		}
+		if err := rr.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		links[linkKey(rr.Namespace, rr.Code)] = rr
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (f *fileResolver) Resolve(_ context.Context, tenant, code string) (resolveResp, error) {
+	rr, ok := (*f.links.Load())[linkKey(tenant, code)]
+	if !ok {
+		return resolveResp{}, errNotFound
+	}
+	return rr, nil
+}
+
+// fallbackResolver answers from primary and, if primary fails with anything
+// other than errNotFound, from fallback. It lets an http or postgres
+// resolver ride out a total outage on a local snapshot.
+type fallbackResolver struct {
+	primary  Resolver
+	fallback Resolver
+	logf     func(level, msg string, fields map[string]interface{})
+}
+
+func (r *fallbackResolver) Resolve(ctx context.Context, tenant, code string) (resolveResp, error) {
+	rr, err := r.primary.Resolve(ctx, tenant, code)
+	if err == nil || errors.Is(err, errNotFound) {
+		return rr, err
+	}
+	frr, ferr := r.fallback.Resolve(ctx, tenant, code)
+	if ferr != nil {
+		// Not in the snapshot either: report the primary failure, since the
+		// snapshot may simply predate the code.
+		return rr, err
+	}
+	resolveFallbackServedTotal.Inc()
+	r.logf("info", "resolved from snapshot fallback", map[string]interface{}{
+		"tenant":     tenant,
+		"code":       code,
+		"err":        err.Error(),
+		"request_id": requestIDFromContext(ctx),
+	})
+	return frr, nil
+}
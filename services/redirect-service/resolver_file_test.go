@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileResolver(t *testing.T) {
+	path := writeTestFile(t, "links.jsonl", `{"code":"abc","long_url":"https://example.com/a"}
+
+{"code":"def","long_url":"http://example.com/d"}
+`)
+	r, err := newFileResolver(path)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	rr, err := r.Resolve(context.Background(), "", "def")
+	if err != nil || rr.LongURL != "http://example.com/d" {
+		t.Fatalf("unexpected result: %+v %v", rr, err)
+	}
+	if _, err := r.Resolve(context.Background(), "", "nope"); !errors.Is(err, errNotFound) {
+		t.Fatalf("expected errNotFound, got %v", err)
+	}
+}
+
+func TestFileResolverLongLine(t *testing.T) {
+	// One record well past bufio's default 64 KiB token limit.
+	rules := make([]string, 2000)
+	for i := range rules {
+		rules[i] = fmt.Sprintf(`{"variant":"v%d","url":"https://example.com/%d","languages":["en"]}`, i, i)
+	}
+	path := writeTestFile(t, "links.jsonl", `{"code":"abc","long_url":"https://example.com/a","rules":[`+strings.Join(rules, ",")+`]}
+{"code":"def","long_url":"https://example.com/d"}
+`)
+	if fi, _ := os.Stat(path); fi.Size() <= 64<<10 {
+		t.Fatalf("expected a snapshot over 64 KiB, got %d bytes", fi.Size())
+	}
+	r, err := newFileResolver(path)
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if rr, err := r.Resolve(context.Background(), "", "abc"); err != nil || len(rr.Rules) != len(rules) {
+		t.Fatalf("unexpected result: %d rules, %v", len(rr.Rules), err)
+	}
+	if _, err := r.Resolve(context.Background(), "", "def"); err != nil {
+		t.Fatalf("expected the line after the long one to load, got %v", err)
+	}
+}
+
+func TestFileResolverRejectsInvalidURL(t *testing.T) {
+	path := writeTestFile(t, "links.jsonl", `{"code":"abc","long_url":"javascript:alert(1)"}`)
+	if _, err := newFileResolver(path); err == nil {
+		t.Fatal("expected err for invalid long_url")
+	}
+}
+
+func TestFileResolverHotReload(t *testing.T) {
+	path := writeTestFile(t, "links.jsonl", `{"code":"abc","long_url":"https://example.com/v1"}`)
+	r, err := newFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logf := func(string, string, map[string]interface{}) {}
+	w := newFileWatcher("snapshot", path, time.Second, r.load, logf)
+
+	rewrite := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite(`{"code":"abc","long_url":"https://example.com/v2"}`, time.Now().Add(time.Minute))
+	w.poll()
+	if rr, _ := r.Resolve(context.Background(), "", "abc"); rr.LongURL != "https://example.com/v2" {
+		t.Fatalf("expected reloaded url, got %q", rr.LongURL)
+	}
+
+	// A broken snapshot must not replace the last good one.
+	rewrite(`{"code":"abc","long_url":"javascript:alert(1)"}`, time.Now().Add(2*time.Minute))
+	w.poll()
+	if rr, _ := r.Resolve(context.Background(), "", "abc"); rr.LongURL != "https://example.com/v2" {
+		t.Fatalf("expected previous snapshot to keep serving, got %q", rr.LongURL)
+	}
+}
+
+func TestFallbackResolver(t *testing.T) {
+	path := writeTestFile(t, "snapshot.jsonl", `{"code":"abc","long_url":"https://example.com/snap"}`)
+	snap, err := newFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := resolverFunc(func(context.Context, string, string) (resolveResp, error) {
+		return resolveResp{}, errCircuitOpen
+	})
+	r := &fallbackResolver{primary: down, fallback: snap, logf: func(string, string, map[string]interface{}) {}}
+
+	rr, err := r.Resolve(context.Background(), "", "abc")
+	if err != nil || rr.LongURL != "https://example.com/snap" {
+		t.Fatalf("expected snapshot answer, got %+v %v", rr, err)
+	}
+	if _, err := r.Resolve(context.Background(), "", "nope"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected primary error for codes missing from the snapshot, got %v", err)
+	}
+
+	// A primary 404 is authoritative and must not be overridden.
+	gone := resolverFunc(func(context.Context, string, string) (resolveResp, error) {
+		return resolveResp{}, errNotFound
+	})
+	r.primary = gone
+	if _, err := r.Resolve(context.Background(), "", "abc"); !errors.Is(err, errNotFound) {
+		t.Fatalf("expected errNotFound, got %v", err)
+	}
+}
+
+func TestFileResolverParsesActivationWindow(t *testing.T) {
+	path := writeTestFile(t, "links.jsonl", `{"code":"abc","long_url":"https://example.com/a","not_before":"2026-03-01T00:00:00Z","expires_at":"2026-03-02T00:00:00+02:00","fallback_url":"https://example.com/over"}`)
+	r, err := newFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := r.Resolve(context.Background(), "", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.NotBefore == nil || !rr.NotBefore.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected not_before: %v", rr.NotBefore)
+	}
+	if rr.ExpiresAt == nil || !rr.ExpiresAt.Equal(time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected expires_at: %v", rr.ExpiresAt)
+	}
+	if got := rr.windowAt(time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)); got != windowExpired {
+		t.Fatalf("expected expired, got %v", got)
+	}
+}
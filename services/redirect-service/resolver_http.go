@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// upstreamError reports a non-2xx, non-404 answer from url-service.
+type upstreamError struct {
+	Status int
+	Msg    string
+}
+
+func (e *upstreamError) Error() string {
+	return "url-service error: " + e.Msg
+}
+
+// upstreamStatus returns the url-service status code carried by err, or 0
+// if the request never got a response.
+func upstreamStatus(err error) int {
+	var ue *upstreamError
+	if errors.As(err, &ue) {
+		return ue.Status
+	}
+	return 0
+}
+
+// httpResolver resolves codes through url-service's GET /urls/{code}, with
+// ?namespace= for tenants other than the default one. With a token it uses
+// GET /internal/urls/{code} instead, which also returns password hashes;
+// without one, password-protected links fail to resolve.
+type httpResolver struct {
+	client  *http.Client
+	baseURL string
+	token   string
+}
+
+func newHTTPResolver(client *http.Client, baseURL, token string) *httpResolver {
+	return &httpResolver{client: client, baseURL: strings.TrimRight(baseURL, "/"), token: token}
+}
+
+func (h *httpResolver) Resolve(ctx context.Context, tenant, code string) (resolveResp, error) {
+	endpoint := h.baseURL + "/urls/" + url.PathEscape(code)
+	if h.token != "" {
+		endpoint = h.baseURL + "/internal/urls/" + url.PathEscape(code)
+	}
+	if tenant != "" {
+		endpoint += "?namespace=" + url.QueryEscape(tenant)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return resolveResp{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	// Propagate request id to url-service for cross-service tracing.
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return resolveResp{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resolveResp{}, errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return resolveResp{}, &upstreamError{Status: resp.StatusCode, Msg: msg}
+	}
+
+	var rr resolveResp
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return resolveResp{}, err
+	}
+	rr.Namespace = tenant
+	if err := rr.validate(); err != nil {
+		return resolveResp{}, fmt.Errorf("url-service: %w", err)
+	}
+	return rr, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPResolver_OK(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/urls/abc" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+
+		// Verify request-id propagation
+		if got := r.Header.Get("X-Request-Id"); got != "req-123" {
+			t.Fatalf("expected X-Request-Id=req-123, got %q", got)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(`{"code":"abc","long_url":"https://example.com"}`))
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Timeout: 2 * time.Second}
+	ctx := context.WithValue(context.Background(), ctxKeyRequestID{}, "req-123")
+	rr, err := newHTTPResolver(c, ts.URL, "").Resolve(ctx, "", "abc")
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if rr.LongURL != "https://example.com" {
+		t.Fatalf("expected https://example.com, got %s", rr.LongURL)
+	}
+}
+
+func TestHTTPResolver_NotFound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Timeout: 2 * time.Second}
+	rr, err := newHTTPResolver(c, ts.URL, "").Resolve(context.Background(), "", "missing")
+	if !errors.Is(err, errNotFound) {
+		t.Fatalf("expected errNotFound, got %v", err)
+	}
+	if rr.LongURL != "" {
+		t.Fatalf("expected empty url, got %s", rr.LongURL)
+	}
+}
+
+func TestHTTPResolver_UpstreamError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Timeout: 2 * time.Second}
+	_, err := newHTTPResolver(c, ts.URL, "").Resolve(context.Background(), "", "abc")
+	if err == nil {
+		t.Fatal("expected err for 503")
+	}
+	if status := upstreamStatus(err); status != 503 {
+		t.Fatalf("expected upstream status 503, got %d", status)
+	}
+}
+
+func TestHTTPResolver_InvalidScheme(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(`{"code":"abc","long_url":"javascript:alert(1)"}`))
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Timeout: 2 * time.Second}
+	_, err := newHTTPResolver(c, ts.URL, "").Resolve(context.Background(), "", "abc")
+	if err == nil || !strings.HasPrefix(err.Error(), "url-service: ") || !strings.Contains(err.Error(), "not an http(s) URL") {
+		t.Fatalf("expected the validation error to be wrapped, got %v", err)
+	}
+}
+
+func TestHTTPResolver_InternalToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/urls/abc" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"code":"abc","long_url":"https://example.com","protected":true,"password_hash":"` + testScryptHash + `"}`))
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Timeout: 2 * time.Second}
+	rr, err := newHTTPResolver(c, ts.URL, "s3cret").Resolve(context.Background(), "", "abc")
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if rr.PasswordHash != testScryptHash {
+		t.Fatalf("expected the password hash, got %q", rr.PasswordHash)
+	}
+}
+
+func TestHTTPResolver_ProtectedWithoutHash(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"code":"abc","created_at":"2024-03-01T12:00:00Z","protected":true}`))
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Timeout: 2 * time.Second}
+	if _, err := newHTTPResolver(c, ts.URL, "").Resolve(context.Background(), "", "abc"); err == nil || !strings.Contains(err.Error(), "protected") {
+		t.Fatalf("expected a protected link without its hash to fail to resolve, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resolveStmt is prepared on every pooled connection so the hot path skips
+// parse/plan round-trips.
+const (
+	resolveStmtName = "resolve_code"
+	resolveStmtSQL  = `SELECT long_url, created_at, COALESCE(redirect_status, 0), not_before, expires_at, COALESCE(fallback_url, ''),
+		COALESCE(max_uses, 0), COALESCE(password_hash, ''),
+		rules, destinations, COALESCE(query_passthrough, ''), path_passthrough,
+		inject_params, COALESCE(inject_conflict, '')
+		FROM urls WHERE namespace = $1 AND code = $2`
+)
+
+// postgresResolver reads links directly from url-service's urls table
+// (migrations V1–V11). Sessions are forced read-only, so it can safely
+// point at a read replica.
+type postgresResolver struct {
+	pool         *pgxpool.Pool
+	queryTimeout time.Duration
+}
+
+func newPostgresResolver(ctx context.Context, cfg Config) (*postgresResolver, error) {
+	if cfg.ResolverDatabaseURL == "" {
+		return nil, errors.New("RESOLVER_DATABASE_URL is required for the postgres resolver")
+	}
+	pcfg, err := pgxpool.ParseConfig(cfg.ResolverDatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	pcfg.MaxConns = int32(cfg.ResolverDBPoolMax)
+	pcfg.MinConns = int32(cfg.ResolverDBPoolMin)
+	pcfg.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	pcfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Prepare(ctx, resolveStmtName, resolveStmtSQL)
+		return err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, pcfg)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresResolver{pool: pool, queryTimeout: cfg.ResolverDBQueryTimeout}, nil
+}
+
+func (p *postgresResolver) Resolve(ctx context.Context, tenant, code string) (resolveResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.queryTimeout)
+	defer cancel()
+
+	rr := resolveResp{Code: code, Namespace: tenant}
+	err := p.pool.QueryRow(ctx, resolveStmtName, tenant, code).Scan(&rr.LongURL, &rr.CreatedAt, &rr.RedirectStatus, &rr.NotBefore, &rr.ExpiresAt, &rr.FallbackURL, &rr.MaxUses, &rr.PasswordHash, &rr.Rules, &rr.Destinations, &rr.QueryPassthrough, &rr.PathPassthrough,
+		&rr.InjectParams, &rr.InjectConflict)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return resolveResp{}, errNotFound
+	}
+	if err != nil {
+		return resolveResp{}, err
+	}
+	if err := rr.validate(); err != nil {
+		return resolveResp{}, err
+	}
+	return rr, nil
+}
+
+func (p *postgresResolver) Close() {
+	p.pool.Close()
+}
+
+// poolStatsCollector exposes pgxpool statistics on /metrics. Values are read
+// from the pool at scrape time rather than tracked separately.
+type poolStatsCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns   *prometheus.Desc
+	idleConns       *prometheus.Desc
+	totalConns      *prometheus.Desc
+	maxConns        *prometheus.Desc
+	acquireCount    *prometheus.Desc
+	acquireDuration *prometheus.Desc
+	emptyAcquire    *prometheus.Desc
+	canceledAcquire *prometheus.Desc
+}
+
+func newPoolStatsCollector(pool *pgxpool.Pool) *poolStatsCollector {
+	return &poolStatsCollector{
+		pool:            pool,
+		acquiredConns:   prometheus.NewDesc("resolver_db_pool_acquired_conns", "Connections currently checked out of the resolver pool", nil, nil),
+		idleConns:       prometheus.NewDesc("resolver_db_pool_idle_conns", "Idle connections in the resolver pool", nil, nil),
+		totalConns:      prometheus.NewDesc("resolver_db_pool_total_conns", "Total connections in the resolver pool", nil, nil),
+		maxConns:        prometheus.NewDesc("resolver_db_pool_max_conns", "Maximum size of the resolver pool", nil, nil),
+		acquireCount:    prometheus.NewDesc("resolver_db_pool_acquires_total", "Total successful connection acquires from the resolver pool", nil, nil),
+		acquireDuration: prometheus.NewDesc("resolver_db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections from the resolver pool", nil, nil),
+		emptyAcquire:    prometheus.NewDesc("resolver_db_pool_empty_acquires_total", "Total acquires that had to wait because the resolver pool was empty", nil, nil),
+		canceledAcquire: prometheus.NewDesc("resolver_db_pool_canceled_acquires_total", "Total acquires canceled by their context", nil, nil),
+	}
+}
+
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquire
+	ch <- c.canceledAcquire
+}
+
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquire, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquire, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveRespValidate(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	cases := []struct {
+		name  string
+		rr    resolveResp
+		valid bool
+	}{
+		{"plain", resolveResp{Code: "a", LongURL: "https://example.com"}, true},
+		{"bad scheme", resolveResp{Code: "a", LongURL: "ftp://example.com"}, false},
+		{"permanent", resolveResp{Code: "a", LongURL: "https://example.com", RedirectStatus: 308}, true},
+		{"not a redirect status", resolveResp{Code: "a", LongURL: "https://example.com", RedirectStatus: 200}, false},
+		{"unsupported redirect status", resolveResp{Code: "a", LongURL: "https://example.com", RedirectStatus: 303}, false},
+		{"window", resolveResp{Code: "a", LongURL: "https://example.com", NotBefore: &start, ExpiresAt: &end}, true},
+		{"inverted window", resolveResp{Code: "a", LongURL: "https://example.com", NotBefore: &end, ExpiresAt: &start}, false},
+		{"fallback", resolveResp{Code: "a", LongURL: "https://example.com", FallbackURL: "https://example.com/over"}, true},
+		{"max uses", resolveResp{Code: "a", LongURL: "https://example.com", MaxUses: 1}, true},
+		{"negative max uses", resolveResp{Code: "a", LongURL: "https://example.com", MaxUses: -1}, false},
+		{"password hash", resolveResp{Code: "a", LongURL: "https://example.com", PasswordHash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"}, true},
+		{"plaintext password", resolveResp{Code: "a", LongURL: "https://example.com", PasswordHash: "hunter2"}, false},
+		{"scrypt password hash", resolveResp{Code: "a", LongURL: "https://example.com", PasswordHash: testScryptHash, Protected: true}, true},
+		{"protected without hash", resolveResp{Code: "a", LongURL: "https://example.com", Protected: true}, false},
+		{"rules", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: "ios", URL: "https://apps.apple.com", Platforms: []string{"ios"}, Languages: []string{"en-GB"}}}}, true},
+		{"rule with bad url", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: "ios", URL: "itms-apps://x"}}}, false},
+		{"rule without variant", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{URL: "https://example.com/x"}}}, false},
+		{"rule with unknown platform", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: "x", URL: "https://example.com/x", Platforms: []string{"blackberry"}}}}, false},
+		{"rule with bad language", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: "x", URL: "https://example.com/x", Languages: []string{"not a tag"}}}}, false},
+		{"rule with long variant", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: strings.Repeat("v", 65), URL: "https://example.com/x"}}}, false},
+		{"rule with 64-character variant", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: strings.Repeat("v", 64), URL: "https://example.com/x"}}}, true},
+		{"rule with countries", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: "x", URL: "https://example.com/x", Countries: []string{"DE", "at"}}}}, true},
+		{"rule with bad country", resolveResp{Code: "a", LongURL: "https://example.com", Rules: []targetRule{{Variant: "x", URL: "https://example.com/x", Countries: []string{"DEU"}}}}, false},
+		{"destinations", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: "a", URL: "https://example.com/a", Weight: 70}, {Variant: "b", URL: "https://example.com/b", Weight: 30}}}, true},
+		{"destination with bad url", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: "a", URL: "https://example.com/a", Weight: 1}, {Variant: "b", URL: "data:text/html,x", Weight: 1}}}, false},
+		{"destinations without weight", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: "a", URL: "https://example.com/a"}}}, false},
+		{"negative weight", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: "a", URL: "https://example.com/a", Weight: 2}, {Variant: "b", URL: "https://example.com/b", Weight: -1}}}, false},
+		{"duplicate variant", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: "a", URL: "https://example.com/a", Weight: 1}, {Variant: "a", URL: "https://example.com/b", Weight: 1}}}, false},
+		{"destination with long variant", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: strings.Repeat("v", 65), URL: "https://example.com/a", Weight: 1}}}, false},
+		{"variant unsafe for cookie", resolveResp{Code: "a", LongURL: "https://example.com", Destinations: []weightedDest{{Variant: "a b;", URL: "https://example.com/a", Weight: 1}}}, false},
+		{"inject params", resolveResp{Code: "a", LongURL: "https://example.com", InjectParams: map[string]string{"utm_campaign": "{code}", "cid": "{request_id}-{country}-{variant}"}, InjectConflict: injectReplace}, true},
+		{"unknown placeholder", resolveResp{Code: "a", LongURL: "https://example.com", InjectParams: map[string]string{"utm_campaign": "{campaign}"}}, false},
+		{"unknown inject conflict", resolveResp{Code: "a", LongURL: "https://example.com", InjectConflict: "merge"}, false},
+		{"bad fallback", resolveResp{Code: "a", LongURL: "https://example.com", FallbackURL: "javascript:alert(1)"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rr.validate()
+			if tc.valid && err != nil {
+				t.Fatalf("expected valid, got %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// reservedRootPaths are first path segments that are never treated as
+// codes in root-path mode (ROOT_PATH_LINKS), whether or not the matching
+// endpoint is mounted. Codes with these names stay reachable under /r/.
+var reservedRootPaths = map[string]bool{
+	"r":           true,
+	"qr":          true,
+	"health":      true,
+	"ready":       true,
+	"metrics":     true,
+	"internal":    true,
+	"favicon.ico": true,
+	"robots.txt":  true,
+}
+
+// isRootLinkPath reports whether an escaped request path is a root-path
+// short link, /{code} or /{code}+ with an optional path suffix.
+func isRootLinkPath(escaped string) bool {
+	seg, _, _ := strings.Cut(strings.TrimPrefix(escaped, "/"), "/")
+	seg = strings.TrimSuffix(seg, "+")
+	if s, err := url.PathUnescape(seg); err == nil {
+		seg = s
+	}
+	seg = strings.TrimSpace(seg)
+	return seg != "" && !reservedRootPaths[seg]
+}
+
+// linkPrefix returns the prefix a short link was requested under: "/r/",
+// or "/" for a root-path link. Links the service hands out for a request
+// (preview continue URLs, cookie paths) use the same form.
+func linkPrefix(r *http.Request) string {
+	if strings.HasPrefix(r.URL.EscapedPath(), "/r/") {
+		return "/r/"
+	}
+	return "/"
+}
+
+// rootHandler is the catch-all for paths no other route matched. In
+// root-path mode it hands short links to redirect; everything else is a
+// minimal 404 (don't leak).
+func rootHandler(redirect http.Handler, rootPathLinks bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rootPathLinks && isRootLinkPath(r.URL.EscapedPath()) {
+			redirect.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "not_found", http.StatusNotFound)
+	})
+}
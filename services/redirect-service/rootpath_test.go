@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsRootLinkPath(t *testing.T) {
+	cases := map[string]bool{
+		"/abc":           true,
+		"/abc+":          true,
+		"/abc/more/path": true,
+		"/a%20b":         true,
+		"/":              false,
+		"/+":             false,
+		"/health":        false,
+		"/health+":       false,
+		"/qr":            false,
+		"/qr/abc":        false,
+		"/internal/x":    false,
+		"/favicon.ico":   false,
+		"/%72":           false, // "r", escaped
+	}
+	for path, want := range cases {
+		if got := isRootLinkPath(path); got != want {
+			t.Fatalf("%s: expected %v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestRootHandler(t *testing.T) {
+	h, _ := newTestRedirectHandler(staticResolver(map[string]string{"abc": "https://example.com/landing"}))
+	h.pathPassthrough = true
+
+	get := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+
+	// Off by default: the catch-all is a plain 404.
+	if rr := get(rootHandler(h, false), "/abc"); rr.Code != http.StatusNotFound || rr.Header().Get("Location") != "" {
+		t.Fatalf("expected 404 without root-path links, got %d", rr.Code)
+	}
+
+	root := rootHandler(h, true)
+	if rr := get(root, "/abc"); rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/landing" {
+		t.Fatalf("expected redirect, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if loc := get(root, "/abc/x").Header().Get("Location"); loc != "https://example.com/landing/x" {
+		t.Fatalf("expected path suffix to be forwarded, got %s", loc)
+	}
+	if rr := get(root, "/abc+"); rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/html; charset=utf-8" ||
+		!strings.Contains(rr.Body.String(), `<a href="/abc" rel="noreferrer">Continue</a>`) {
+		t.Fatalf("expected a preview page continuing to /abc, got %d %s", rr.Code, rr.Body.String())
+	}
+	for _, path := range []string{"/", "/missing", "/robots.txt", "/internal/x"} {
+		if rr := get(root, path); rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestRootPathCookies(t *testing.T) {
+	// The unlock cookie is scoped to /{code}, so it comes back on the next
+	// root-path visit.
+	resolver, _ := protectedResolver(t, "hunter2")
+	h, _ := newTestRedirectHandler(resolver)
+	root := rootHandler(h, true)
+	req := httptest.NewRequest(http.MethodPost, "/doc", strings.NewReader(url.Values{"password": {"hunter2"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	root.ServeHTTP(rr, req)
+	cookies := rr.Result().Cookies()
+	if rr.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Path != "/doc" {
+		t.Fatalf("expected an unlock cookie for /doc, got %d %+v", rr.Code, cookies)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/doc", nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	root.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected the cookie to unlock /doc, got %d", rr.Code)
+	}
+
+	h, _ = newTestRedirectHandler(resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		return resolveResp{
+			Code:         code,
+			LongURL:      "https://example.com/",
+			Destinations: []weightedDest{{Variant: "control", URL: "https://example.com/control", Weight: 1}},
+		}, nil
+	}))
+	rr = httptest.NewRecorder()
+	rootHandler(h, true).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/exp", nil))
+	cookies = rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "rs_variant_exp" || cookies[0].Path != "/exp" {
+		t.Fatalf("expected a variant cookie for /exp, got %+v", cookies)
+	}
+}
+
+func TestRouteLabel(t *testing.T) {
+	cases := []struct {
+		path          string
+		rootPathLinks bool
+		want          string
+	}{
+		{"/r/abc", false, "/r/{code}"},
+		{"/qr/abc", false, "/qr/{code}"},
+		{"/health", true, "/health"},
+		{"/abc", false, "unknown"},
+		{"/abc", true, "/{code}"},
+		{"/abc/x", true, "/{code}"},
+		{"/", true, "unknown"},
+		{"/favicon.ico", true, "unknown"},
+	}
+	for _, tc := range cases {
+		if got := routeLabel(httptest.NewRequest(http.MethodGet, tc.path, nil), tc.rootPathLinks); got != tc.want {
+			t.Fatalf("%s (root %v): expected %s, got %s", tc.path, tc.rootPathLinks, tc.want, got)
+		}
+	}
+}
+
+func TestQRCodeRootPath(t *testing.T) {
+	h := newTestQRHandler()
+	h.rootPathLinks = true
+	etagFor := func(target string) string {
+		sum := sha256.Sum256([]byte("v1|" + target + "|png|256|M|4"))
+		return `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/qr/abc", nil))
+	if got := rr.Header().Get("ETag"); got != etagFor("https://s.example/abc") {
+		t.Fatalf("expected the QR code to encode the root-path URL, got ETag %s", got)
+	}
+}
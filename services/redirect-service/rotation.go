@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// variantCookiePrefix is followed by the code. The cookie remembers which
+// destination a visitor was assigned so it survives IP changes.
+const variantCookiePrefix = "rs_variant_"
+
+// variantCookieTTL bounds how long an assignment is remembered.
+const variantCookieTTL = 30 * 24 * time.Hour
+
+// weightedDest is one arm of an A/B rotation. Visitors are split across
+// destinations in proportion to Weight.
+type weightedDest struct {
+	Variant string `json:"variant"`
+	URL     string `json:"url"`
+	Weight  int    `json:"weight"`
+}
+
+// validateDestinations checks dests and normalises their URLs in place.
+func validateDestinations(dests []weightedDest) error {
+	total := 0
+	seen := map[string]bool{}
+	for i := range dests {
+		d := &dests[i]
+		if d.Variant == "" || d.Variant == defaultVariant || d.Variant == fallbackVariant {
+			return fmt.Errorf("destination variant must be set and not %q or %q", defaultVariant, fallbackVariant)
+		}
+		if len(d.Variant) > maxVariantLength {
+			return fmt.Errorf("destination variant %q is longer than %d characters", d.Variant, maxVariantLength)
+		}
+		// The variant is stored verbatim in the sticky cookie.
+		if strings.IndexFunc(d.Variant, func(c rune) bool {
+			return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.')
+		}) >= 0 {
+			return fmt.Errorf("destination variant %q may only contain letters, digits, '-', '_' and '.'", d.Variant)
+		}
+		if seen[d.Variant] {
+			return fmt.Errorf("duplicate destination variant %q", d.Variant)
+		}
+		seen[d.Variant] = true
+		dest, err := normalizeDestination(d.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url for variant %q: %w", d.Variant, err)
+		}
+		d.URL = dest
+		if d.Weight < 0 {
+			return fmt.Errorf("negative weight for variant %q", d.Variant)
+		}
+		total += d.Weight
+	}
+	if len(dests) > 0 && total == 0 {
+		return fmt.Errorf("destination weights sum to zero")
+	}
+	return nil
+}
+
+// pickDestination chooses a destination for the visitor behind r. A
+// variant remembered in the cookie wins while it still has weight;
+// otherwise the choice is a stable hash of code, client IP and User-Agent,
+// so cookieless clients stay on one variant too. assigned reports whether
+// the caller should (re)set the cookie.
+func pickDestination(r *http.Request, code string, dests []weightedDest, trustProxy bool) (d weightedDest, assigned bool) {
+	if c, err := r.Cookie(variantCookiePrefix + code); err == nil {
+		for _, d := range dests {
+			if d.Variant == c.Value && d.Weight > 0 {
+				return d, false
+			}
+		}
+	}
+
+	total := 0
+	for _, d := range dests {
+		total += d.Weight
+	}
+	h := fnv.New64a()
+	h.Write([]byte(code + "\x00" + clientIP(r, trustProxy) + "\x00" + r.UserAgent()))
+	n := int(h.Sum64() % uint64(total))
+	for _, d := range dests {
+		if n < d.Weight {
+			return d, true
+		}
+		n -= d.Weight
+	}
+	// Unreachable for validated destinations.
+	return dests[len(dests)-1], true
+}
+
+// setVariantCookie pins the visitor to variant, scoped to the link under
+// the prefix it was requested with (see linkPrefix).
+func setVariantCookie(w http.ResponseWriter, r *http.Request, code, variant string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookiePrefix + code,
+		Value:    variant,
+		Path:     linkPrefix(r) + url.PathEscape(code),
+		MaxAge:   int(variantCookieTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
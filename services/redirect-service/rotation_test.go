@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPickDestinationSplitsByWeight(t *testing.T) {
+	dests := []weightedDest{
+		{Variant: "a", URL: "https://example.com/a", Weight: 70},
+		{Variant: "b", URL: "https://example.com/b", Weight: 30},
+		{Variant: "off", URL: "https://example.com/off", Weight: 0},
+	}
+
+	counts := map[string]int{}
+	const visitors = 10_000
+	for i := range visitors {
+		req := httptest.NewRequest(http.MethodGet, "/r/exp", nil)
+		req.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:1234", i>>16&0xff, i>>8&0xff, i&0xff)
+		d, assigned := pickDestination(req, "exp", dests, false)
+		if !assigned {
+			t.Fatal("expected a new visitor to be assigned")
+		}
+		counts[d.Variant]++
+	}
+	if counts["off"] != 0 {
+		t.Fatalf("expected no traffic to a zero-weight destination, got %d", counts["off"])
+	}
+	if share := float64(counts["a"]) / visitors; share < 0.67 || share > 0.73 {
+		t.Fatalf("expected ~70%% on a, got %.3f (%v)", share, counts)
+	}
+}
+
+func TestPickDestinationIsSticky(t *testing.T) {
+	dests := []weightedDest{
+		{Variant: "a", URL: "https://example.com/a", Weight: 50},
+		{Variant: "b", URL: "https://example.com/b", Weight: 50},
+	}
+	newReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/r/exp", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("User-Agent", "test-agent")
+		return req
+	}
+
+	first, _ := pickDestination(newReq(), "exp", dests, false)
+	for range 10 {
+		if d, _ := pickDestination(newReq(), "exp", dests, false); d.Variant != first.Variant {
+			t.Fatalf("expected the same IP and UA to stay on %s, got %s", first.Variant, d.Variant)
+		}
+	}
+
+	other := "a"
+	if first.Variant == "a" {
+		other = "b"
+	}
+	req := newReq()
+	req.AddCookie(&http.Cookie{Name: "rs_variant_exp", Value: other})
+	if d, assigned := pickDestination(req, "exp", dests, false); d.Variant != other || assigned {
+		t.Fatalf("expected the cookie to win without reassignment, got %s (assigned %v)", d.Variant, assigned)
+	}
+
+	// A cookie for a variant that was switched off or removed is ignored.
+	dests[0].Weight, dests[1].Weight = 0, 100
+	req = newReq()
+	req.AddCookie(&http.Cookie{Name: "rs_variant_exp", Value: "a"})
+	if d, assigned := pickDestination(req, "exp", dests, false); d.Variant != "b" || !assigned {
+		t.Fatalf("expected reassignment to b, got %s (assigned %v)", d.Variant, assigned)
+	}
+}
+
+func TestRedirectHandlerRotation(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		return resolveResp{
+			Code:    code,
+			LongURL: "https://example.com/",
+			Rules:   []targetRule{{Variant: "ios", URL: "https://apps.apple.com/app/id1", Platforms: []string{platformIOS}}},
+			Destinations: []weightedDest{
+				{Variant: "control", URL: "https://example.com/control", Weight: 1},
+			},
+		}, nil
+	})
+	h, sink := newTestRedirectHandler(resolver)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/r/exp", nil))
+	if loc := rr.Header().Get("Location"); loc != "https://example.com/control" {
+		t.Fatalf("expected rotated destination, got %q", loc)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "rs_variant_exp" || cookies[0].Value != "control" || cookies[0].Path != "/r/exp" {
+		t.Fatalf("unexpected variant cookie: %+v", cookies)
+	}
+	if evt := <-sink.ch; evt.Variant != "control" {
+		t.Fatalf("expected variant control in analytics event, got %+v", evt)
+	}
+
+	// Targeting rules take precedence over rotation.
+	req := httptest.NewRequest(http.MethodGet, "/r/exp", nil)
+	req.Header.Set("User-Agent", uaIPhone)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "https://apps.apple.com/app/id1" {
+		t.Fatalf("expected rule to win over rotation, got %q", loc)
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Fatal("expected no variant cookie when a rule matched")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Platforms a targeting rule can match. Anything that isn't recognisably
+// iOS or Android counts as desktop.
+const (
+	platformIOS     = "ios"
+	platformAndroid = "android"
+	platformDesktop = "desktop"
+)
+
+// Variants reported when no rule chose the destination: long_url was used,
+// or fallback_url because the link has ended.
+const (
+	defaultVariant  = "default"
+	fallbackVariant = "fallback"
+)
+
+// maxVariantLength is the longest variant name analytics-service accepts in
+// an event; longer ones would get the click dropped.
+const maxVariantLength = 64
+
+// targetRule sends visitors matching every non-empty condition to URL.
+// Rules are tried in order and the first match wins. Variant names the
+// rule in logs and analytics.
+type targetRule struct {
+	Variant   string   `json:"variant"`
+	URL       string   `json:"url"`
+	Platforms []string `json:"platforms,omitempty"`
+	// Languages are BCP 47 tags matched against the visitor's most
+	// preferred Accept-Language entry. A tag without a region ("pt")
+	// matches every region; one with a region ("pt-BR") only that one.
+	Languages []string `json:"languages,omitempty"`
+	// Countries are ISO 3166-1 alpha-2 codes matched against the visitor's
+	// GeoIP country. They never match when GEOIP_DATABASE is unset.
+	Countries []string `json:"countries,omitempty"`
+}
+
+func (t *targetRule) validate() error {
+	if t.Variant == "" || t.Variant == defaultVariant || t.Variant == fallbackVariant {
+		return fmt.Errorf("rule variant must be set and not %q or %q", defaultVariant, fallbackVariant)
+	}
+	if len(t.Variant) > maxVariantLength {
+		return fmt.Errorf("rule variant %q is longer than %d characters", t.Variant, maxVariantLength)
+	}
+	dest, err := normalizeDestination(t.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url for variant %q: %w", t.Variant, err)
+	}
+	t.URL = dest
+	for _, p := range t.Platforms {
+		if p != platformIOS && p != platformAndroid && p != platformDesktop {
+			return fmt.Errorf("unknown platform %q for variant %q", p, t.Variant)
+		}
+	}
+	for _, l := range t.Languages {
+		if _, err := language.Parse(l); err != nil {
+			return fmt.Errorf("invalid language %q for variant %q", l, t.Variant)
+		}
+	}
+	for _, c := range t.Countries {
+		if !isCountryCode(c) {
+			return fmt.Errorf("invalid country %q for variant %q", c, t.Variant)
+		}
+	}
+	return nil
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && isASCIILetter(s[0]) && isASCIILetter(s[1])
+}
+
+func isASCIILetter(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// visitor is what targeting rules are matched against.
+type visitor struct {
+	Platform string
+	Language language.Tag // language.Und if none was sent
+	Country  string       // upper-case ISO code, "" if unknown
+}
+
+// visitorFromRequest describes the client behind r. geo may be nil, in
+// which case the country is unknown.
+func visitorFromRequest(r *http.Request, geo *geoIP, trustProxy bool) visitor {
+	v := visitor{
+		Platform: platformFromUA(r.UserAgent()),
+		Language: preferredLanguage(r.Header.Get("Accept-Language")),
+	}
+	if geo != nil {
+		v.Country = geo.Country(clientIP(r, trustProxy))
+	}
+	return v
+}
+
+// platformFromUA classifies a User-Agent. iPadOS 13+ sends a desktop
+// Safari UA by default and is classed as desktop.
+func platformFromUA(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return platformIOS
+	case strings.Contains(ua, "Android"):
+		return platformAndroid
+	}
+	return platformDesktop
+}
+
+// preferredLanguage returns the highest-weighted tag in an Accept-Language
+// header.
+func preferredLanguage(header string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(tags) == 0 {
+		return language.Und
+	}
+	return tags[0]
+}
+
+func (t targetRule) matches(v visitor) bool {
+	if len(t.Platforms) > 0 && !slices.Contains(t.Platforms, v.Platform) {
+		return false
+	}
+	if len(t.Languages) > 0 && !matchesLanguage(t.Languages, v.Language) {
+		return false
+	}
+	if len(t.Countries) > 0 && !slices.ContainsFunc(t.Countries, func(c string) bool {
+		return v.Country != "" && strings.EqualFold(c, v.Country)
+	}) {
+		return false
+	}
+	return true
+}
+
+func matchesLanguage(want []string, got language.Tag) bool {
+	if got == language.Und {
+		return false
+	}
+	gotBase, _ := got.Base()
+	gotRegion, gotConf := got.Region()
+	for _, w := range want {
+		tag, err := language.Parse(w)
+		if err != nil {
+			continue
+		}
+		base, _ := tag.Base()
+		region, conf := tag.Region()
+		if base != gotBase {
+			continue
+		}
+		// Region() guesses a region for bare tags; only an explicit one
+		// narrows the match, and only an explicit one satisfies it.
+		if conf == language.Exact && (gotConf != language.Exact || region != gotRegion) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// target picks the destination for v: the URL of the first matching rule,
+// or long_url.
+func (rr resolveResp) target(v visitor) (dest, variant string) {
+	for _, t := range rr.Rules {
+		if t.matches(v) {
+			return t.URL, t.Variant
+		}
+	}
+	return rr.LongURL, defaultVariant
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	uaIPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	uaAndroid = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36"
+	uaDesktop = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+)
+
+func TestPlatformFromUA(t *testing.T) {
+	cases := map[string]string{
+		uaIPhone:  platformIOS,
+		uaAndroid: platformAndroid,
+		uaDesktop: platformDesktop,
+		"Mozilla/5.0 (iPad; CPU OS 12_5 like Mac OS X) AppleWebKit/605.1.15": platformIOS,
+		"curl/8.5.0": platformDesktop,
+		"":           platformDesktop,
+	}
+	for ua, want := range cases {
+		if got := platformFromUA(ua); got != want {
+			t.Errorf("platformFromUA(%q) = %s, want %s", ua, got, want)
+		}
+	}
+}
+
+func TestResolveRespTarget(t *testing.T) {
+	rr := resolveResp{
+		LongURL: "https://example.com/web",
+		Rules: []targetRule{
+			{Variant: "ios-br", URL: "https://example.com/ios-br", Platforms: []string{platformIOS}, Languages: []string{"pt-BR"}},
+			{Variant: "ios", URL: "https://apps.apple.com/app/id1", Platforms: []string{platformIOS}},
+			{Variant: "android", URL: "https://play.google.com/store/apps/details?id=x", Platforms: []string{platformAndroid}},
+			{Variant: "german", URL: "https://example.com/de", Languages: []string{"de"}},
+		},
+	}
+
+	cases := []struct {
+		name, ua, acceptLanguage, want string
+	}{
+		{"iphone", uaIPhone, "en-US,en;q=0.9", "ios"},
+		{"iphone brazil", uaIPhone, "pt-BR,pt;q=0.8", "ios-br"},
+		{"iphone portugal", uaIPhone, "pt-PT", "ios"},
+		{"iphone bare pt", uaIPhone, "pt", "ios"},
+		{"android", uaAndroid, "", "android"},
+		{"android german", uaAndroid, "de-DE", "android"},
+		{"desktop german", uaDesktop, "de-AT,en;q=0.5", "german"},
+		{"desktop prefers english", uaDesktop, "en;q=0.9,de;q=0.5", defaultVariant},
+		{"desktop", uaDesktop, "fr", defaultVariant},
+		{"garbage language", uaDesktop, "!!!", defaultVariant},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/r/abc", nil)
+			req.Header.Set("User-Agent", tc.ua)
+			req.Header.Set("Accept-Language", tc.acceptLanguage)
+			dest, variant := rr.target(visitorFromRequest(req, nil, false))
+			if variant != tc.want {
+				t.Fatalf("expected variant %s, got %s (%s)", tc.want, variant, dest)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerTargeting(t *testing.T) {
+	resolver := resolverFunc(func(_ context.Context, _, code string) (resolveResp, error) {
+		return resolveResp{
+			Code:           code,
+			LongURL:        "https://example.com/web",
+			RedirectStatus: http.StatusMovedPermanently,
+			Rules:          []targetRule{{Variant: "ios", URL: "https://apps.apple.com/app/id1", Platforms: []string{platformIOS}}},
+		}, nil
+	})
+	h, sink := newTestRedirectHandler(resolver)
+
+	req := httptest.NewRequest(http.MethodGet, "/r/app", nil)
+	req.Header.Set("User-Agent", uaIPhone)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "https://apps.apple.com/app/id1" {
+		t.Fatalf("expected App Store redirect, got %q", loc)
+	}
+	if vary := rr.Header().Get("Vary"); vary != "User-Agent, Accept-Language" {
+		t.Fatalf("expected Vary header, got %q", vary)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "private, max-age=3600" {
+		t.Fatalf("expected a private permanent redirect, got %q", cc)
+	}
+	if evt := <-sink.ch; evt.Variant != "ios" {
+		t.Fatalf("expected variant ios in analytics event, got %+v", evt)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// tenantMap maps request hosts to code namespaces (TENANTS), so that
+// brand-a.link/r/abc and brand-b.link/r/abc can be different links. A nil
+// map means a single tenant: every host serves the default namespace "",
+// which holds every link created without one.
+type tenantMap map[string]string
+
+// maxNamespaceLen matches the code length limit.
+const maxNamespaceLen = 64
+
+// parseTenants parses TENANTS: comma-separated host=namespace pairs, e.g.
+// "brand-a.link=brand-a,brand-b.link=brand-b,sho.rt=". An empty namespace
+// is the default one. Hosts are matched without their port.
+func parseTenants(s string) (tenantMap, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	t := tenantMap{}
+	for _, pair := range strings.Split(s, ",") {
+		host, ns, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not host=namespace", pair)
+		}
+		host, ns = strings.TrimSpace(host), strings.TrimSpace(ns)
+		if host == "" || strings.ContainsAny(host, ":/[]") {
+			return nil, fmt.Errorf("invalid host in %q", pair)
+		}
+		host = normalizeHost(host)
+		if !isNamespace(ns) {
+			return nil, fmt.Errorf("invalid namespace in %q", pair)
+		}
+		if _, dup := t[host]; dup {
+			return nil, fmt.Errorf("host %s given more than once", host)
+		}
+		t[host] = ns
+	}
+	return t, nil
+}
+
+// isNamespace allows letters, digits, '-', '_' and '.', so namespaces are
+// safe in logs, metrics and query strings.
+func isNamespace(s string) bool {
+	if len(s) > maxNamespaceLen {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeHost lowercases a Host header value and drops its port and any
+// trailing dot, so "Brand-A.link.:443" matches "brand-a.link".
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// lookup returns the namespace served on host.
+func (t tenantMap) lookup(host string) (string, bool) {
+	if t == nil {
+		return "", true
+	}
+	ns, ok := t[normalizeHost(host)]
+	return ns, ok
+}
+
+// namespaces returns every namespace that can be requested, always
+// including the default one, sorted.
+func (t tenantMap) namespaces() []string {
+	seen := map[string]bool{"": true}
+	out := []string{""}
+	for _, ns := range t {
+		if !seen[ns] {
+			seen[ns] = true
+			out = append(out, ns)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// linkKey identifies a code within a namespace in maps and caches. NUL
+// can't appear in either part.
+func linkKey(tenant, code string) string {
+	return tenant + "\x00" + code
+}
+
+// tenant returns the namespace for the request's Host, answering 421
+// Misdirected Request for hosts that aren't in TENANTS.
+func (h *redirectHandler) tenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ns, ok := h.tenants.lookup(r.Host)
+	if !ok {
+		tenantRejectedTotal.Inc()
+		h.logf("info", "unknown host", map[string]interface{}{
+			"host":       r.Host,
+			"request_id": requestIDFromContext(r.Context()),
+		})
+		http.Error(w, "misdirected_request", http.StatusMisdirectedRequest)
+	}
+	return ns, ok
+}
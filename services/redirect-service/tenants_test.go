@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTenants(t *testing.T) {
+	tm, err := parseTenants(" Brand-A.link = brand-a , brand-b.link.=brand-b, sho.rt=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := tenantMap{"brand-a.link": "brand-a", "brand-b.link": "brand-b", "sho.rt": ""}
+	if !reflect.DeepEqual(tm, want) {
+		t.Fatalf("expected %v, got %v", want, tm)
+	}
+	if ns := tm.namespaces(); !reflect.DeepEqual(ns, []string{"", "brand-a", "brand-b"}) {
+		t.Fatalf("unexpected namespaces %v", ns)
+	}
+
+	if tm, err := parseTenants(""); err != nil || tm != nil {
+		t.Fatalf("expected no tenants, got %v %v", tm, err)
+	}
+	for _, bad := range []string{
+		"brand-a.link",
+		"=brand-a",
+		"brand-a.link:8080=brand-a",
+		"brand-a.link=brand a",
+		"brand-a.link=a,BRAND-A.link=b",
+	} {
+		if _, err := parseTenants(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTenantLookup(t *testing.T) {
+	tm := tenantMap{"brand-a.link": "brand-a"}
+	for _, host := range []string{"brand-a.link", "BRAND-A.link", "brand-a.link:443", "brand-a.link."} {
+		if ns, ok := tm.lookup(host); !ok || ns != "brand-a" {
+			t.Fatalf("%s: expected brand-a, got %q %v", host, ns, ok)
+		}
+	}
+	if _, ok := tm.lookup("evil.example"); ok {
+		t.Fatal("expected unknown host to be rejected")
+	}
+	// Without TENANTS every host serves the default namespace.
+	if ns, ok := tenantMap(nil).lookup("anything.example"); !ok || ns != "" {
+		t.Fatalf("expected default namespace, got %q %v", ns, ok)
+	}
+}
+
+func TestRedirectHandlerTenants(t *testing.T) {
+	links := map[string]string{
+		linkKey("brand-a", "abc"): "https://brand-a.example/",
+		linkKey("brand-b", "abc"): "https://brand-b.example/",
+		linkKey("", "abc"):        "https://example.com/",
+	}
+	resolver := resolverFunc(func(_ context.Context, tenant, code string) (resolveResp, error) {
+		dest, ok := links[linkKey(tenant, code)]
+		if !ok {
+			return resolveResp{}, errNotFound
+		}
+		return resolveResp{Code: code, Namespace: tenant, LongURL: dest}, nil
+	})
+	h, _ := newTestRedirectHandler(resolver)
+	h.tenants = tenantMap{"brand-a.link": "brand-a", "brand-b.link": "brand-b", "sho.rt": ""}
+
+	get := func(host, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for host, want := range map[string]string{
+		"brand-a.link":     "https://brand-a.example/",
+		"Brand-B.link:443": "https://brand-b.example/",
+		"sho.rt":           "https://example.com/",
+	} {
+		rr := get(host, "/r/abc")
+		if rr.Code != http.StatusFound || rr.Header().Get("Location") != want {
+			t.Fatalf("%s: expected redirect to %s, got %d %s", host, want, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+	if rr := get("evil.example", "/r/abc"); rr.Code != http.StatusMisdirectedRequest {
+		t.Fatalf("expected 421 for an unknown host, got %d", rr.Code)
+	}
+	if rr := get("evil.example", "/r/abc+"); rr.Code != http.StatusMisdirectedRequest {
+		t.Fatalf("expected 421 for a preview on an unknown host, got %d", rr.Code)
+	}
+}
+
+func TestRedirectHandlerTenantEvent(t *testing.T) {
+	h, sink := newTestRedirectHandler(staticResolver(map[string]string{"abc": "https://example.com"}))
+	h.tenants = tenantMap{"brand-a.link": "brand-a"}
+
+	req := httptest.NewRequest(http.MethodGet, "/r/abc", nil)
+	req.Host = "brand-a.link"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	select {
+	case evt := <-sink.ch:
+		if evt.Tenant != "brand-a" {
+			t.Fatalf("expected tenant brand-a, got %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected analytics event")
+	}
+}
+
+func TestCachingResolverSeparatesTenants(t *testing.T) {
+	calls := map[string]int{}
+	backend := resolverFunc(func(_ context.Context, tenant, code string) (resolveResp, error) {
+		calls[linkKey(tenant, code)]++
+		return resolveResp{Code: code, Namespace: tenant, LongURL: "https://" + tenant + ".example/" + code}, nil
+	})
+	r := newCachingResolver(Config{
+		ResolveCacheSize:  10,
+		ResolveCacheTTL:   time.Minute,
+		NegativeCacheSize: 10,
+		NegativeCacheTTL:  time.Minute,
+		Tenants:           tenantMap{"brand-a.link": "brand-a", "brand-b.link": "brand-b"},
+	}, backend, nil)
+
+	for range 2 {
+		for _, tenant := range []string{"brand-a", "brand-b"} {
+			rr, err := r.Resolve(context.Background(), tenant, "abc")
+			if err != nil || rr.LongURL != "https://"+tenant+".example/abc" {
+				t.Fatalf("%s: unexpected result %+v %v", tenant, rr, err)
+			}
+		}
+	}
+	if calls[linkKey("brand-a", "abc")] != 1 || calls[linkKey("brand-b", "abc")] != 1 {
+		t.Fatalf("expected one backend call per tenant, got %v", calls)
+	}
+
+	// Invalidations carry only the code, so they evict it everywhere.
+	r.Invalidate("abc")
+	_, _ = r.Resolve(context.Background(), "brand-a", "abc")
+	_, _ = r.Resolve(context.Background(), "brand-b", "abc")
+	if calls[linkKey("brand-a", "abc")] != 2 || calls[linkKey("brand-b", "abc")] != 2 {
+		t.Fatalf("expected invalidation in every tenant, got %v", calls)
+	}
+}
+
+func TestFileResolverNamespaces(t *testing.T) {
+	path := writeTestFile(t, "links.jsonl", `{"code":"abc","long_url":"https://example.com/a"}
+{"code":"abc","namespace":"brand-b","long_url":"https://brand-b.example/a"}
+`)
+	r, err := newFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr, err := r.Resolve(context.Background(), "", "abc"); err != nil || rr.LongURL != "https://example.com/a" {
+		t.Fatalf("unexpected result: %+v %v", rr, err)
+	}
+	if rr, err := r.Resolve(context.Background(), "brand-b", "abc"); err != nil || rr.LongURL != "https://brand-b.example/a" || rr.Namespace != "brand-b" {
+		t.Fatalf("unexpected result: %+v %v", rr, err)
+	}
+	if _, err := r.Resolve(context.Background(), "brand-a", "abc"); err != errNotFound {
+		t.Fatalf("expected errNotFound, got %v", err)
+	}
+}
+
+func TestHTTPResolverNamespace(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/urls/abc" || r.URL.Query().Get("namespace") != "brand-b" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"code":"abc","long_url":"https://brand-b.example"}`))
+	}))
+	defer ts.Close()
+
+	rr, err := newHTTPResolver(&http.Client{Timeout: 2 * time.Second}, ts.URL, "").Resolve(context.Background(), "brand-b", "abc")
+	if err != nil || rr.Namespace != "brand-b" {
+		t.Fatalf("unexpected result: %+v %v", rr, err)
+	}
+}
+
+func TestQRHandlerTenantHost(t *testing.T) {
+	h := newTestQRHandler()
+	h.redirect.tenants = tenantMap{"brand-a.link": "brand-a"}
+	if got := tenantBaseURL("https://s.example:8443", "Brand-A.link:8443"); got != "https://brand-a.link:8443" {
+		t.Fatalf("unexpected base URL %s", got)
+	}
+
+	get := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/qr/abc", nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get("evil.example"); rr.Code != http.StatusMisdirectedRequest {
+		t.Fatalf("expected 421 for an unknown host, got %d", rr.Code)
+	}
+
+	// The ETag covers the encoded URL, so it tells which host was used.
+	tenantTag := get("brand-a.link").Header().Get("ETag")
+	h.redirect.tenants = nil
+	if defaultTag := get("brand-a.link").Header().Get("ETag"); tenantTag == "" || tenantTag == defaultTag {
+		t.Fatalf("expected the tenant host in the QR target, got ETags %s and %s", tenantTag, defaultTag)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// errUsesExhausted is returned by a usageCounter once a link has been
+// followed max_uses times.
+var errUsesExhausted = errors.New("link uses exhausted")
+
+// usageCounter enforces per-link max_uses. Unlike analyticsSink it is on the
+// redirect path and strongly consistent across replicas: a use is either
+// recorded before the redirect is issued or the redirect is refused.
+type usageCounter interface {
+	// Consume records one use of code in namespace and returns the uses
+	// left, or errUsesExhausted if all maxUses have already been taken.
+	Consume(ctx context.Context, namespace, code string, maxUses int) (remaining int, err error)
+	// Remaining returns the uses left without consuming one.
+	Remaining(ctx context.Context, namespace, code string, maxUses int) (int, error)
+}
+
+// The increment only applies while the count is below the limit, so
+// concurrent consumers on any replica serialise on the row lock and at
+// most maxUses of them get a row back.
+const (
+	consumeUseSQL = `INSERT INTO link_uses (namespace, code, used) VALUES ($1, $2, 1)
+		ON CONFLICT (namespace, code) DO UPDATE SET used = link_uses.used + 1
+		WHERE link_uses.used < $3
+		RETURNING used`
+	usedSQL = `SELECT used FROM link_uses WHERE namespace = $1 AND code = $2`
+)
+
+// postgresUsageCounter keeps use counts in the link_uses table (url-service
+// migrations V5 and V11). It must point at the primary.
+type postgresUsageCounter struct {
+	pool         *pgxpool.Pool
+	queryTimeout time.Duration
+}
+
+func newPostgresUsageCounter(ctx context.Context, cfg Config) (*postgresUsageCounter, error) {
+	pcfg, err := pgxpool.ParseConfig(cfg.UsageDatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	pcfg.MaxConns = int32(cfg.UsageDBPoolMax)
+	pool, err := pgxpool.NewWithConfig(ctx, pcfg)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresUsageCounter{pool: pool, queryTimeout: cfg.UsageDBQueryTimeout}, nil
+}
+
+func (c *postgresUsageCounter) Consume(ctx context.Context, namespace, code string, maxUses int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout)
+	defer cancel()
+
+	var used int
+	err := c.pool.QueryRow(ctx, consumeUseSQL, namespace, code, maxUses).Scan(&used)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errUsesExhausted
+	}
+	if err != nil {
+		return 0, err
+	}
+	return maxUses - used, nil
+}
+
+func (c *postgresUsageCounter) Remaining(ctx context.Context, namespace, code string, maxUses int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout)
+	defer cancel()
+
+	var used int
+	err := c.pool.QueryRow(ctx, usedSQL, namespace, code).Scan(&used)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return maxUses, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return max(maxUses-used, 0), nil
+}
+
+func (c *postgresUsageCounter) Close() {
+	c.pool.Close()
+}
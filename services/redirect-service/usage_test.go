@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// memoryUsageCounter is an in-process usageCounter for handler tests.
+type memoryUsageCounter struct {
+	mu   sync.Mutex
+	used map[string]int
+	err  error
+}
+
+func (c *memoryUsageCounter) Consume(_ context.Context, namespace, code string, maxUses int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	key := linkKey(namespace, code)
+	if c.used[key] >= maxUses {
+		return 0, errUsesExhausted
+	}
+	c.used[key]++
+	return maxUses - c.used[key], nil
+}
+
+func (c *memoryUsageCounter) Remaining(_ context.Context, namespace, code string, maxUses int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return max(maxUses-c.used[linkKey(namespace, code)], 0), nil
+}
+
+// TestPostgresUsageCounterIsAtomic needs a real Postgres; see
+// testDatabaseURL.
+func TestPostgresUsageCounterIsAtomic(t *testing.T) {
+	dsn := testDatabaseURL(t)
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS link_uses (
+		namespace TEXT NOT NULL DEFAULT '', code TEXT NOT NULL, used INTEGER NOT NULL, PRIMARY KEY (namespace, code))`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, `DELETE FROM link_uses WHERE code = 'rs-test-uses'`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = conn.Exec(ctx, `DELETE FROM link_uses WHERE code = 'rs-test-uses'`)
+	})
+
+	c, err := newPostgresUsageCounter(ctx, Config{
+		UsageDatabaseURL:    dsn,
+		UsageDBPoolMax:      8,
+		UsageDBQueryTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if n, err := c.Remaining(ctx, "", "rs-test-uses", 5); err != nil || n != 5 {
+		t.Fatalf("expected 5 remaining before first use, got %d %v", n, err)
+	}
+
+	// 20 concurrent clicks on a 5-use link: exactly 5 may get through.
+	const maxUses = 5
+	var ok, exhausted atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Consume(ctx, "", "rs-test-uses", maxUses)
+			switch {
+			case err == nil:
+				ok.Add(1)
+			case errors.Is(err, errUsesExhausted):
+				exhausted.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ok.Load() != maxUses || exhausted.Load() != 20-maxUses {
+		t.Fatalf("expected %d consumed and %d exhausted, got %d and %d", maxUses, 20-maxUses, ok.Load(), exhausted.Load())
+	}
+	if n, err := c.Remaining(ctx, "", "rs-test-uses", maxUses); err != nil || n != 0 {
+		t.Fatalf("expected 0 remaining, got %d %v", n, err)
+	}
+}
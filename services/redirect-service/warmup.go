@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// Warm-up sources selectable with WARMUP_SOURCE.
+const (
+	warmupFromFile      = "file"
+	warmupFromAnalytics = "analytics"
+)
+
+// maxAnalyticsTopN is the most codes analytics-service's GET /stats returns
+// (its limit parameter's upper bound).
+const maxAnalyticsTopN = 1000
+
+// warmupKey is a code to preload and the namespace it is looked up in.
+type warmupKey struct {
+	Tenant string
+	Code   string
+}
+
+// loadWarmupCodes returns up to cfg.WarmupTopN hot codes from the configured
+// source, hottest first.
+func loadWarmupCodes(ctx context.Context, cfg Config, client *http.Client) ([]warmupKey, error) {
+	var (
+		codes []warmupKey
+		err   error
+	)
+	switch cfg.WarmupSource {
+	case warmupFromFile:
+		codes, err = readWarmupFile(cfg.WarmupFile)
+	case warmupFromAnalytics:
+		codes, err = fetchTopCodes(ctx, client, cfg.AnalyticsBaseURL, min(cfg.WarmupTopN, maxAnalyticsTopN))
+	default:
+		return nil, fmt.Errorf("unknown warm-up source %q", cfg.WarmupSource)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) > cfg.WarmupTopN {
+		codes = codes[:cfg.WarmupTopN]
+	}
+	return codes, nil
+}
+
+// readWarmupFile reads one code per line, optionally preceded by its
+// namespace and whitespace ("brand-b abc"). Blank lines and lines starting
+// with # are ignored.
+func readWarmupFile(path string) ([]warmupKey, error) {
+	if path == "" {
+		return nil, errors.New("WARMUP_FILE is required for the file warm-up source")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var codes []warmupKey
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		switch fields := strings.Fields(text); len(fields) {
+		case 1:
+			codes = append(codes, warmupKey{Code: fields[0]})
+		case 2:
+			codes = append(codes, warmupKey{Tenant: fields[0], Code: fields[1]})
+		default:
+			return nil, fmt.Errorf("%s:%d: expected a code or a namespace and a code", path, line)
+		}
+	}
+	return codes, sc.Err()
+}
+
+// fetchTopCodes reads the n most-clicked codes, in every namespace, from
+// analytics-service's GET /stats.
+func fetchTopCodes(ctx context.Context, client *http.Client, baseURL string, n int) ([]warmupKey, error) {
+	endpoint := strings.TrimRight(baseURL, "/") + "/stats?limit=" + strconv.Itoa(n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if rid := requestIDFromContext(ctx); rid != "" {
+		req.Header.Set(RequestIDHeader, rid)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("analytics-service error: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var stats struct {
+		Top []struct {
+			Namespace string `json:"namespace"`
+			Code      string `json:"code"`
+		} `json:"top"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	codes := make([]warmupKey, 0, len(stats.Top))
+	for _, t := range stats.Top {
+		codes = append(codes, warmupKey{Tenant: t.Namespace, Code: t.Code})
+	}
+	return codes, nil
+}
+
+// warmCache resolves codes through resolver, each in its own namespace,
+// with at most concurrency lookups in flight, so they land in the resolve
+// cache before the pod takes traffic. It returns when every lookup has
+// finished or ctx is done, whichever comes first; lookups still running at
+// the deadline complete in the background.
+func warmCache(ctx context.Context, resolver Resolver, codes []warmupKey, concurrency int) (ok, failed int64) {
+	var okN, failedN atomic.Int64
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, k := range codes {
+			if ctx.Err() != nil {
+				break
+			}
+			g.Go(func() error {
+				// Not-found codes still count as warmed: the negative cache
+				// now holds them.
+				if _, err := resolver.Resolve(ctx, k.Tenant, k.Code); err != nil && !errors.Is(err, errNotFound) {
+					failedN.Add(1)
+				} else {
+					okN.Add(1)
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return okN.Load(), failedN.Load()
+}
+
+// runWarmup loads hot codes and preloads them, bounded by cfg.WarmupTimeout.
+// Failures are logged but never fatal: a cold cache is slower, not broken.
+func runWarmup(ctx context.Context, cfg Config, resolver Resolver, client *http.Client, logf func(level, msg string, fields map[string]interface{})) {
+	start := time.Now()
+	rid := uuid.NewString()
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, ctxKeyRequestID{}, rid), cfg.WarmupTimeout)
+	defer cancel()
+
+	if cfg.WarmupSource == warmupFromAnalytics && cfg.WarmupTopN > maxAnalyticsTopN {
+		logf("info", "WARMUP_TOP_N capped at what analytics-service returns", map[string]interface{}{
+			"top_n":      cfg.WarmupTopN,
+			"max":        maxAnalyticsTopN,
+			"request_id": rid,
+		})
+	}
+	codes, err := loadWarmupCodes(ctx, cfg, client)
+	if err != nil {
+		logf("error", "warm-up source failed (starting cold)", map[string]interface{}{
+			"source":     cfg.WarmupSource,
+			"err":        err.Error(),
+			"request_id": rid,
+		})
+		return
+	}
+
+	ok, failed := warmCache(ctx, resolver, codes, cfg.WarmupConcurrency)
+	logf("info", "warm-up finished", map[string]interface{}{
+		"source":       cfg.WarmupSource,
+		"codes":        len(codes),
+		"warmed":       ok,
+		"failed":       failed,
+		"deadline_hit": ctx.Err() != nil,
+		"ms":           time.Since(start).Milliseconds(),
+		"request_id":   rid,
+	})
+}
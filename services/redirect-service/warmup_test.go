@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadWarmupCodesFromFile(t *testing.T) {
+	path := writeTestFile(t, "hot.txt", "# hottest first\nabc\n\n  brand-b\tdef  \nghi\n")
+
+	codes, err := loadWarmupCodes(context.Background(), Config{
+		WarmupSource: warmupFromFile,
+		WarmupFile:   path,
+		WarmupTopN:   2,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []warmupKey{{Code: "abc"}, {Tenant: "brand-b", Code: "def"}}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("expected %v, got %v", want, codes)
+	}
+
+	bad := writeTestFile(t, "bad.txt", "abc\na b c\n")
+	if _, err := readWarmupFile(bad); err == nil || err.Error() != bad+":2: expected a code or a namespace and a code" {
+		t.Fatalf("expected an error naming the line, got %v", err)
+	}
+}
+
+func TestLoadWarmupCodesFromAnalytics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("limit") != "100" {
+			t.Errorf("expected WARMUP_TOP_N as the limit, got %q", r.URL.RawQuery)
+		}
+		if r.Header.Get(RequestIDHeader) != "rid-1" {
+			t.Errorf("expected request id to be forwarded, got %q", r.Header.Get(RequestIDHeader))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"uptime_seconds":1,"tracked_codes":3,"top":[{"namespace":"","code":"a","count":9},{"namespace":"brand-b","code":"b","count":5},{"code":"c","count":1}]}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKeyRequestID{}, "rid-1")
+	codes, err := loadWarmupCodes(ctx, Config{
+		WarmupSource:     warmupFromAnalytics,
+		AnalyticsBaseURL: srv.URL,
+		WarmupTopN:       100,
+	}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []warmupKey{{Code: "a"}, {Tenant: "brand-b", Code: "b"}, {Code: "c"}}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("expected %v, got %v", want, codes)
+	}
+}
+
+func TestWarmCachePopulatesCache(t *testing.T) {
+	var calls, inFlight, maxInFlight atomic.Int32
+	backend := resolverFunc(func(_ context.Context, tenant, code string) (resolveResp, error) {
+		calls.Add(1)
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		switch code {
+		case "missing":
+			return resolveResp{}, errNotFound
+		case "broken":
+			return resolveResp{}, errors.New("boom")
+		case "d":
+			if tenant != "brand-b" {
+				return resolveResp{}, errNotFound
+			}
+		}
+		return resolveResp{Code: code, LongURL: "https://example.com/" + code}, nil
+	})
+	r := newCachingResolver(Config{
+		ResolveCacheSize:  10,
+		ResolveCacheTTL:   time.Minute,
+		NegativeCacheSize: 10,
+		NegativeCacheTTL:  time.Minute,
+	}, backend, nil)
+
+	codes := []warmupKey{{Code: "a"}, {Code: "b"}, {Code: "c"}, {Tenant: "brand-b", Code: "d"}, {Code: "missing"}, {Code: "broken"}}
+	ok, failed := warmCache(context.Background(), r, codes, 2)
+	if ok != 5 || failed != 1 {
+		t.Fatalf("expected 5 ok and 1 failed, got %d and %d", ok, failed)
+	}
+	if got := maxInFlight.Load(); got > 2 {
+		t.Fatalf("expected at most 2 concurrent lookups, got %d", got)
+	}
+
+	// Warmed codes, including the not-found one, are now served from cache.
+	before := calls.Load()
+	for _, k := range codes[:4] {
+		if _, err := r.Resolve(context.Background(), k.Tenant, k.Code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Resolve(context.Background(), "", "missing"); !errors.Is(err, errNotFound) {
+		t.Fatalf("expected errNotFound, got %v", err)
+	}
+	if got := calls.Load(); got != before {
+		t.Fatalf("expected warmed codes to be cached, got %d extra backend calls", got-before)
+	}
+}
+
+func TestWarmCacheStopsAtDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	backend := resolverFunc(func(context.Context, string, string) (resolveResp, error) {
+		<-release
+		return resolveResp{}, errNotFound
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	ok, failed := warmCache(ctx, backend, []warmupKey{{Code: "a"}, {Code: "b"}, {Code: "c"}}, 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected warm-up to stop at the deadline, took %v", elapsed)
+	}
+	if ok != 0 || failed != 0 {
+		t.Fatalf("expected no finished lookups, got %d ok and %d failed", ok, failed)
+	}
+}
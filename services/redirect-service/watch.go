@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// fileWatcher polls a file's modification time and size and calls load
+// whenever either changes. Polling rather than inotify keeps it working on
+// Kubernetes ConfigMap and Secret volumes, where updates arrive as symlink
+// swaps that inotify watches on the original path miss.
+//
+// load is expected to build its new state completely before swapping it in,
+// so a failed reload leaves the previous state serving.
+type fileWatcher struct {
+	name     string
+	path     string
+	interval time.Duration
+	load     func() error
+	logf     func(level, msg string, fields map[string]interface{})
+
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(name, path string, interval time.Duration, load func() error, logf func(level, msg string, fields map[string]interface{})) *fileWatcher {
+	w := &fileWatcher{name: name, path: path, interval: interval, load: load, logf: logf}
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime, w.size = fi.ModTime(), fi.Size()
+	}
+	return w
+}
+
+// Run polls until ctx is done. It is a no-op if interval is zero.
+func (w *fileWatcher) Run(ctx context.Context) {
+	if w.interval <= 0 {
+		return
+	}
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w.poll()
+		}
+	}
+}
+
+// poll reloads the file if it changed since the last check. A version that
+// fails to load is not retried until the file changes again.
+func (w *fileWatcher) poll() {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		// Keep serving the last good state; the file may be mid-replace.
+		return
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return
+	}
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	if err := w.load(); err != nil {
+		fileReloadsTotal.WithLabelValues(w.name, "error").Inc()
+		w.logf("error", "file reload failed (keeping previous version)", map[string]interface{}{
+			"file": w.name,
+			"path": w.path,
+			"err":  err.Error(),
+		})
+		return
+	}
+	fileReloadsTotal.WithLabelValues(w.name, "ok").Inc()
+	w.logf("info", "file reloaded", map[string]interface{}{
+		"file": w.name,
+		"path": w.path,
+	})
+}
@@ -0,0 +1,9 @@
+-- Per-link tracking parameters redirect-service adds to the destination at
+-- redirect time, as a JSON object of name -> template (e.g.
+-- {"utm_campaign": "{code}"}), and the rule for parameters the destination
+-- already has. NULL means the service-wide INJECT_PARAMS / INJECT_CONFLICT.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS inject_params JSONB
+        CHECK (inject_params IS NULL OR jsonb_typeof(inject_params) = 'object'),
+    ADD COLUMN IF NOT EXISTS inject_conflict TEXT
+        CHECK (inject_conflict IN ('keep', 'replace'));
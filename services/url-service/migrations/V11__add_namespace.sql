@@ -0,0 +1,16 @@
+-- Vanity domains: codes are unique per namespace instead of globally, so
+-- brand-a.link/r/abc and brand-b.link/r/abc can be different links.
+-- redirect-service maps request hosts to namespaces (TENANTS). '' is the
+-- default namespace, which every existing link belongs to. The url_changes
+-- payload stays the bare code; redirect-service evicts it in every
+-- namespace.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS namespace TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_pkey;
+ALTER TABLE urls ADD PRIMARY KEY (namespace, code);
+
+-- Uses are counted per link, so per namespace too.
+ALTER TABLE link_uses
+    ADD COLUMN IF NOT EXISTS namespace TEXT NOT NULL DEFAULT '';
+ALTER TABLE link_uses DROP CONSTRAINT IF EXISTS link_uses_pkey;
+ALTER TABLE link_uses ADD PRIMARY KEY (namespace, code);
@@ -0,0 +1,14 @@
+-- Click-limited links. max_uses is read by redirect-service through the
+-- resolve contract; NULL means unlimited.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS max_uses INTEGER CHECK (max_uses > 0);
+
+-- Uses consumed per code, written by redirect-service (USAGE_DATABASE_URL)
+-- with a single conditional upsert so the limit holds across replicas.
+-- Kept apart from urls so counting clicks doesn't fire the url_changes
+-- trigger and evict the link from every cache. No foreign key: snapshot
+-- resolvers may serve codes that aren't in urls.
+CREATE TABLE IF NOT EXISTS link_uses (
+    code  TEXT    PRIMARY KEY,
+    used  INTEGER NOT NULL CHECK (used > 0)
+);
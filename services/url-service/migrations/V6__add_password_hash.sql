@@ -0,0 +1,5 @@
+-- Password-protected links. scrypt hash (written by url-service; bcrypt is
+-- accepted too) of the password redirect-service asks for before
+-- redirecting; NULL means the link is public.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS password_hash TEXT;
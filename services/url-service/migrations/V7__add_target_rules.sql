@@ -0,0 +1,6 @@
+-- Device/language targeting rules read by redirect-service. A JSON array of
+-- {"variant", "url", "platforms"?, "languages"?} objects tried in order;
+-- NULL means every visitor goes to long_url.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS rules JSONB
+        CHECK (rules IS NULL OR jsonb_typeof(rules) = 'array');
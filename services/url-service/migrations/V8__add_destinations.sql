@@ -0,0 +1,6 @@
+-- Weighted A/B destinations read by redirect-service. A JSON array of
+-- {"variant", "url", "weight"} objects; visitors no targeting rule matched
+-- are split across them by weight instead of going to long_url.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS destinations JSONB
+        CHECK (destinations IS NULL OR jsonb_typeof(destinations) = 'array');
@@ -0,0 +1,6 @@
+-- Per-link query string and path passthrough read by redirect-service. NULL
+-- means the service-wide QUERY_PASSTHROUGH / PATH_PASSTHROUGH default.
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS query_passthrough TEXT
+        CHECK (query_passthrough IN ('off', 'merge', 'override')),
+    ADD COLUMN IF NOT EXISTS path_passthrough BOOLEAN;
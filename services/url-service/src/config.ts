@@ -14,6 +14,8 @@ export interface Config {
   corsOrigins: string[];
   readyRateLimitMax: number;
   readyRateLimitWindowMs: number;
+  // Bearer token for the /internal routes; unset disables them.
+  internalApiToken?: string;
 }
 
 function mustBeUrl(s: string): string {
@@ -55,6 +57,10 @@ export function loadConfig(): Config {
     .map(s => s.trim())
     .filter(Boolean);
 
+  // Shared with redirect-service (URL_SERVICE_INTERNAL_TOKEN), which reads
+  // password hashes from GET /internal/urls/:code.
+  const internalApiToken = process.env.INTERNAL_API_TOKEN || undefined;
+
   if (storageMode === "postgres" && !databaseUrl) {
     throw new Error("STORAGE_MODE=postgres requires DATABASE_URL");
   }
@@ -73,5 +79,6 @@ export function loadConfig(): Config {
     corsOrigins,
     readyRateLimitMax,
     readyRateLimitWindowMs,
+    internalApiToken,
   };
 }
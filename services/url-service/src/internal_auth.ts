@@ -0,0 +1,10 @@
+import { createHash, timingSafeEqual } from "crypto";
+
+// Reports whether an Authorization header carries "Bearer <token>".
+// Both sides are hashed first so the comparison is constant-time even when
+// the lengths differ.
+export function bearerTokenMatches(header: string | undefined, token: string): boolean {
+  if (!header?.startsWith("Bearer ")) return false;
+  const digest = (s: string) => createHash("sha256").update(s).digest();
+  return timingSafeEqual(digest(header.slice("Bearer ".length)), digest(token));
+}
@@ -0,0 +1,31 @@
+import { randomBytes, scrypt } from "crypto";
+
+// Link passwords are stored as PHC-style scrypt strings:
+//   $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+// with unpadded base64 salt and hash. redirect-service verifies them.
+const LOG_N = 15;
+const R = 8;
+const P = 1;
+const KEY_LEN = 32;
+// scrypt needs 128 * N * r bytes; Node's default cap is exactly 32 MiB.
+const MAX_MEM = 64 * 1024 * 1024;
+
+export const PASSWORD_MIN_LENGTH = 8;
+export const PASSWORD_MAX_LENGTH = 128;
+
+function derive(password: string, salt: Buffer, logN: number, r: number, p: number, keyLen: number): Promise<Buffer> {
+  return new Promise((resolve, reject) => {
+    scrypt(password, salt, keyLen, { N: 2 ** logN, r, p, maxmem: MAX_MEM }, (err, key) => {
+      if (err) reject(err);
+      else resolve(key);
+    });
+  });
+}
+
+const b64 = (buf: Buffer) => buf.toString("base64").replace(/=+$/, "");
+
+export async function hashPassword(password: string): Promise<string> {
+  const salt = randomBytes(16);
+  const key = await derive(password, salt, LOG_N, R, P, KEY_LEN);
+  return `$scrypt$ln=${LOG_N},r=${R},p=${P}$${b64(salt)}$${b64(key)}`;
+}
@@ -1,5 +1,5 @@
 import Fastify from "fastify";
-import type { FastifyError } from "fastify";
+import type { FastifyError, FastifyReply, FastifyRequest } from "fastify";
 import cors from "@fastify/cors";
 import helmet from "@fastify/helmet";
 import rateLimit from "@fastify/rate-limit";
@@ -8,6 +8,9 @@ import type { UrlStore } from "./storage.js";
 import { MemoryUrlStore } from "./storage_memory.js";
 import { PostgresUrlStore } from "./storage_postgres.js";
 import { validateHttpUrl } from "./validate_url.js";
+import { isPending, publicUrlRecordResponse, urlRecordResponse } from "./url_record.js";
+import { hashPassword, PASSWORD_MAX_LENGTH, PASSWORD_MIN_LENGTH } from "./password.js";
+import { bearerTokenMatches } from "./internal_auth.js";
 import { getOrCreateRequestId } from "./request_id.js";
 import { registry } from "./metrics.js";
 import { httpRequestsTotal, httpRequestDurationSeconds } from "./metrics.js";
@@ -172,7 +175,8 @@ app.post(
         type: "object",
         required: ["long_url"],
         properties: {
-          long_url: { type: "string", minLength: 1, maxLength: 2048 }
+          long_url: { type: "string", minLength: 1, maxLength: 2048 },
+          password: { type: "string", minLength: PASSWORD_MIN_LENGTH, maxLength: PASSWORD_MAX_LENGTH }
         }
       },
       response: {
@@ -181,7 +185,8 @@ app.post(
           properties: {
             code: { type: "string" },
             short_url: { type: "string" },
-            long_url: { type: "string" }
+            long_url: { type: "string" },
+            protected: { type: "boolean" }
           }
         },
         400: {
@@ -196,7 +201,7 @@ app.post(
     }
   },
   async (req, reply) => {
-    const body = req.body as { long_url: string };
+    const body = req.body as { long_url: string; password?: string };
     const longUrl = body.long_url;
 
     const res = validateHttpUrl(longUrl);
@@ -204,53 +209,174 @@ app.post(
       return reply.code(400).send({ error: res.error });
     }
 
-    const rec = await store.create(longUrl);
+    const passwordHash = body.password === undefined ? undefined : await hashPassword(body.password);
+    const rec = await store.create(longUrl, passwordHash);
     const shortUrl = `${config.baseUrl.replace(/\/+$/, "")}/r/${rec.code}`;
 
     return reply.code(201).send({
       code: rec.code,
       short_url: shortUrl,
-      long_url: rec.longUrl
+      long_url: rec.longUrl,
+      protected: rec.passwordHash ? true : undefined
     });
   }
 );
 
+// Link settings as returned by GET /urls/:code and GET /internal/urls/:code.
+const urlRecordProperties = {
+  code: { type: "string" },
+  namespace: { type: "string" },
+  long_url: { type: "string" },
+  created_at: { type: "string" },
+  redirect_status: { type: "integer" },
+  not_before: { type: "string" },
+  expires_at: { type: "string" },
+  fallback_url: { type: "string" },
+  max_uses: { type: "integer" },
+  rules: {
+    type: "array",
+    items: {
+      type: "object",
+      properties: {
+        variant: { type: "string" },
+        url: { type: "string" },
+        platforms: { type: "array", items: { type: "string" } },
+        languages: { type: "array", items: { type: "string" } },
+        countries: { type: "array", items: { type: "string" } }
+      }
+    }
+  },
+  destinations: {
+    type: "array",
+    items: {
+      type: "object",
+      properties: {
+        variant: { type: "string" },
+        url: { type: "string" },
+        weight: { type: "integer" }
+      }
+    }
+  },
+  query_passthrough: { type: "string" },
+  path_passthrough: { type: "boolean" },
+  inject_params: { type: "object", additionalProperties: { type: "string" } },
+  inject_conflict: { type: "string" },
+  protected: { type: "boolean" }
+};
+
+const codeParams = {
+  type: "object",
+  required: ["code"],
+  properties: { code: { type: "string", minLength: 1, maxLength: 64 } }
+};
+
+const namespaceQuery = {
+  type: "object",
+  properties: { namespace: { type: "string", maxLength: 64, pattern: "^[A-Za-z0-9._-]*$" } }
+};
+
+const errorResponse = {
+  type: "object",
+  properties: { error: { type: "string" } }
+};
+
 app.get(
   "/urls/:code",
   {
     schema: {
-      params: {
-        type: "object",
-        required: ["code"],
-        properties: { code: { type: "string", minLength: 1, maxLength: 64 } }
-      },
+      params: codeParams,
+      querystring: namespaceQuery,
       response: {
-        200: {
-          type: "object",
-          properties: {
-            code: { type: "string" },
-            long_url: { type: "string" }
-          }
-        },
-        404: {
-          type: "object",
-          properties: { error: { type: "string" } }
-        }
+        200: { type: "object", properties: urlRecordProperties },
+        404: errorResponse
       }
     }
   },
   async (req, reply) => {
     const { code } = req.params as { code: string };
-    const rec = await store.get(code);
-    if (!rec) return reply.code(404).send({ error: "not_found" });
+    const { namespace } = req.query as { namespace?: string };
+    const rec = await store.get(code, namespace ?? "");
+    if (!rec || isPending(rec)) return reply.code(404).send({ error: "not_found" });
 
-    return reply.send({
-      code: rec.code,
-      long_url: rec.longUrl
-    });
+    // Protected links are only served in full on the internal route below.
+    return reply.send(publicUrlRecordResponse(rec));
   }
 );
 
+// Internal routes for redirect-service and operators, authenticated with
+// INTERNAL_API_TOKEN and not registered without it.
+if (config.internalApiToken) {
+  const token = config.internalApiToken;
+  const requireToken = async (req: FastifyRequest, reply: FastifyReply) => {
+    if (!bearerTokenMatches(req.headers.authorization, token)) {
+      return reply.code(401).send({ error: "unauthorized" });
+    }
+  };
+
+  app.get(
+    "/internal/urls/:code",
+    {
+      onRequest: requireToken,
+      schema: {
+        params: codeParams,
+        querystring: namespaceQuery,
+        response: {
+          200: {
+            type: "object",
+            properties: { ...urlRecordProperties, password_hash: { type: "string" } }
+          },
+          401: errorResponse,
+          404: errorResponse
+        }
+      }
+    },
+    async (req, reply) => {
+      const { code } = req.params as { code: string };
+      const { namespace } = req.query as { namespace?: string };
+      const rec = await store.get(code, namespace ?? "");
+      if (!rec) return reply.code(404).send({ error: "not_found" });
+
+      return reply.send({ ...urlRecordResponse(rec), password_hash: rec.passwordHash });
+    }
+  );
+
+  app.put(
+    "/internal/urls/:code/password",
+    {
+      onRequest: requireToken,
+      schema: {
+        params: codeParams,
+        querystring: namespaceQuery,
+        body: {
+          type: "object",
+          required: ["password"],
+          properties: {
+            password: {
+              type: ["string", "null"],
+              minLength: PASSWORD_MIN_LENGTH,
+              maxLength: PASSWORD_MAX_LENGTH
+            }
+          }
+        },
+        response: {
+          401: errorResponse,
+          404: errorResponse
+        }
+      }
+    },
+    async (req, reply) => {
+      const { code } = req.params as { code: string };
+      const { namespace } = req.query as { namespace?: string };
+      const { password } = req.body as { password: string | null };
+      const passwordHash = password === null ? null : await hashPassword(password);
+      if (!(await store.setPasswordHash(code, namespace ?? "", passwordHash))) {
+        return reply.code(404).send({ error: "not_found" });
+      }
+      return reply.code(204).send();
+    }
+  );
+}
+
 app.setErrorHandler((err: FastifyError, _req, reply) => {
   app.log.error({ err }, "request failed");
 
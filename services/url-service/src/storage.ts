@@ -1,11 +1,54 @@
 export interface UrlRecord {
   code: string;
+  // Code namespace of a vanity domain; "" is the default namespace.
+  namespace: string;
   longUrl: string;
   createdAt: string;
+  // Redirect status redirect-service should use (301/302/307/308);
+  // undefined means its DEFAULT_REDIRECT_STATUS.
+  redirectStatus?: number;
+  // Optional activation window and the URL served once it has ended.
+  notBefore?: string;
+  expiresAt?: string;
+  fallbackUrl?: string;
+  // Number of redirects allowed before the link is used up.
+  maxUses?: number;
+  // Device/language targeting rules, tried in order by redirect-service.
+  rules?: TargetRule[];
+  // Weighted A/B destinations used when no rule matches.
+  destinations?: WeightedDestination[];
+  // Query string ("off" | "merge" | "override") and path suffix forwarding;
+  // undefined means redirect-service's service-wide default.
+  queryPassthrough?: string;
+  pathPassthrough?: boolean;
+  // Tracking parameter templates added at redirect time, and whether they
+  // "keep" or "replace" parameters the destination already has.
+  injectParams?: Record<string, string>;
+  injectConflict?: string;
+  // scrypt hash of the link password (see password.ts); never returned by
+  // the public API.
+  passwordHash?: string;
+}
+
+export interface TargetRule {
+  variant: string;
+  url: string;
+  platforms?: string[];
+  languages?: string[];
+  countries?: string[];
+}
+
+export interface WeightedDestination {
+  variant: string;
+  url: string;
+  weight: number;
 }
 
 export interface UrlStore {
   ping(): Promise<void>;
-  create(longUrl: string): Promise<UrlRecord>;
-  get(code: string): Promise<UrlRecord | null>;
+  create(longUrl: string, passwordHash?: string): Promise<UrlRecord>;
+  get(code: string, namespace?: string): Promise<UrlRecord | null>;
+  // Sets or (with null) removes a link's password; false if there is no
+  // such link.
+  setPasswordHash(code: string, namespace: string, passwordHash: string | null): Promise<boolean>;
 }
@@ -8,12 +8,12 @@ export class MemoryUrlStore implements UrlStore {
     // nothing — in-memory store is always available
   }
 
-  async create(longUrl: string): Promise<UrlRecord> {
+  async create(longUrl: string, passwordHash?: string): Promise<UrlRecord> {
     // 7 chars is readable; collision extremely unlikely, but we still guard
     for (let i = 0; i < 3; i++) {
       const code = nanoid(7);
       if (!this.map.has(code)) {
-        const rec: UrlRecord = { code, longUrl, createdAt: new Date().toISOString() };
+        const rec: UrlRecord = { code, namespace: "", longUrl, createdAt: new Date().toISOString(), passwordHash };
         this.map.set(code, rec);
         return rec;
       }
@@ -21,7 +21,15 @@ export class MemoryUrlStore implements UrlStore {
     throw new Error("Failed to generate unique code");
   }
 
-  async get(code: string): Promise<UrlRecord | null> {
-    return this.map.get(code) ?? null;
+  async get(code: string, namespace = ""): Promise<UrlRecord | null> {
+    // Links created here are all in the default namespace.
+    return namespace === "" ? this.map.get(code) ?? null : null;
   }
-}
\ No newline at end of file
+
+  async setPasswordHash(code: string, namespace: string, passwordHash: string | null): Promise<boolean> {
+    const rec = await this.get(code, namespace);
+    if (!rec) return false;
+    rec.passwordHash = passwordHash ?? undefined;
+    return true;
+  }
+}
@@ -19,19 +19,25 @@ export class PostgresUrlStore implements UrlStore {
     await this.pool.query("SELECT 1");
   }
 
-  async create(longUrl: string): Promise<UrlRecord> {
+  async create(longUrl: string, passwordHash?: string): Promise<UrlRecord> {
     // Generate in DB using retry loop: simplest safe approach without sequences.
     // (We can move to a dedicated “code generator” later.)
     for (let i = 0; i < 5; i++) {
       const code = this.randomCode();
       try {
         const res = await this.pool.query(
-          `INSERT INTO urls (code, long_url) VALUES ($1, $2)
-           RETURNING code, long_url, created_at`,
-          [code, longUrl]
+          `INSERT INTO urls (code, long_url, password_hash) VALUES ($1, $2, $3)
+           RETURNING code, long_url, created_at, password_hash`,
+          [code, longUrl, passwordHash ?? null]
         );
         const row = res.rows[0];
-        return { code: row.code, longUrl: row.long_url, createdAt: row.created_at.toISOString() };
+        return {
+          code: row.code,
+          namespace: "",
+          longUrl: row.long_url,
+          createdAt: row.created_at.toISOString(),
+          passwordHash: row.password_hash ?? undefined
+        };
       } catch (e: any) {
         // 23505 = unique_violation
         if (e?.code === "23505") continue;
@@ -41,14 +47,42 @@ export class PostgresUrlStore implements UrlStore {
     throw new Error("Failed to generate unique code");
   }
 
-  async get(code: string): Promise<UrlRecord | null> {
+  async get(code: string, namespace = ""): Promise<UrlRecord | null> {
     const res = await this.pool.query(
-      `SELECT code, long_url, created_at FROM urls WHERE code = $1`,
-      [code]
+      `SELECT code, namespace, long_url, created_at, redirect_status, not_before, expires_at, fallback_url, max_uses,
+              rules, destinations, query_passthrough, path_passthrough,
+              inject_params, inject_conflict, password_hash
+       FROM urls WHERE namespace = $1 AND code = $2`,
+      [namespace, code]
     );
     if (res.rowCount === 0) return null;
     const row = res.rows[0];
-    return { code: row.code, longUrl: row.long_url, createdAt: row.created_at.toISOString() };
+    return {
+      code: row.code,
+      namespace: row.namespace,
+      longUrl: row.long_url,
+      createdAt: row.created_at.toISOString(),
+      redirectStatus: row.redirect_status ?? undefined,
+      notBefore: row.not_before?.toISOString(),
+      expiresAt: row.expires_at?.toISOString(),
+      fallbackUrl: row.fallback_url ?? undefined,
+      maxUses: row.max_uses ?? undefined,
+      rules: row.rules ?? undefined,
+      destinations: row.destinations ?? undefined,
+      queryPassthrough: row.query_passthrough ?? undefined,
+      pathPassthrough: row.path_passthrough ?? undefined,
+      injectParams: row.inject_params ?? undefined,
+      injectConflict: row.inject_conflict ?? undefined,
+      passwordHash: row.password_hash ?? undefined
+    };
+  }
+
+  async setPasswordHash(code: string, namespace: string, passwordHash: string | null): Promise<boolean> {
+    const res = await this.pool.query(
+      `UPDATE urls SET password_hash = $3 WHERE namespace = $1 AND code = $2`,
+      [namespace, code, passwordHash]
+    );
+    return (res.rowCount ?? 0) > 0;
   }
 
   private randomCode(): string {
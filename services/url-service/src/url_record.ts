@@ -0,0 +1,45 @@
+import type { UrlRecord } from "./storage.js";
+
+// Link settings as returned by GET /internal/urls/:code, minus the password
+// hash.
+export function urlRecordResponse(rec: UrlRecord) {
+  return {
+    code: rec.code,
+    namespace: rec.namespace || undefined,
+    long_url: rec.longUrl,
+    created_at: rec.createdAt,
+    redirect_status: rec.redirectStatus,
+    not_before: rec.notBefore,
+    expires_at: rec.expiresAt,
+    fallback_url: rec.fallbackUrl,
+    max_uses: rec.maxUses,
+    rules: rec.rules,
+    destinations: rec.destinations,
+    query_passthrough: rec.queryPassthrough,
+    path_passthrough: rec.pathPassthrough,
+    inject_params: rec.injectParams,
+    inject_conflict: rec.injectConflict,
+    protected: rec.passwordHash ? true : undefined
+  };
+}
+
+// Whether a link's not_before is still in the future. The public
+// GET /urls/:code answers 404 for pending links, like redirect-service, so
+// they can't be discovered early.
+export function isPending(rec: UrlRecord, now: Date = new Date()): boolean {
+  return rec.notBefore !== undefined && Date.parse(rec.notBefore) > now.getTime();
+}
+
+// Link settings as returned by the publicly routed GET /urls/:code. The
+// destinations of protected and click-limited links are only served on the
+// internal route, so that reading the record neither gets around the
+// password nor reveals a limited link without using it up.
+export function publicUrlRecordResponse(rec: UrlRecord) {
+  if (rec.passwordHash) {
+    return { code: rec.code, created_at: rec.createdAt, protected: true };
+  }
+  if (rec.maxUses && rec.maxUses > 0) {
+    return { code: rec.code, created_at: rec.createdAt, max_uses: rec.maxUses };
+  }
+  return urlRecordResponse(rec);
+}
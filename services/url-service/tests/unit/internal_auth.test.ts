@@ -0,0 +1,18 @@
+import { describe, expect, test } from "vitest";
+import { bearerTokenMatches } from "../../src/internal_auth.js";
+
+describe("bearerTokenMatches", () => {
+  test("accepts the bearer token", () => {
+    expect(bearerTokenMatches("Bearer s3cret", "s3cret")).toBe(true);
+  });
+
+  test("rejects a wrong or missing token", () => {
+    expect(bearerTokenMatches("Bearer wrong", "s3cret")).toBe(false);
+    expect(bearerTokenMatches(undefined, "s3cret")).toBe(false);
+  });
+
+  test("requires the Bearer scheme", () => {
+    expect(bearerTokenMatches("s3cret", "s3cret")).toBe(false);
+    expect(bearerTokenMatches("Basic s3cret", "s3cret")).toBe(false);
+  });
+});
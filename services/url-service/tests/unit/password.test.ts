@@ -0,0 +1,25 @@
+import { describe, expect, test } from "vitest";
+import { scryptSync } from "crypto";
+import { hashPassword } from "../../src/password.js";
+
+describe("hashPassword", () => {
+  test("produces a salted scrypt hash", async () => {
+    const a = await hashPassword("correct horse");
+    const b = await hashPassword("correct horse");
+    expect(a).toMatch(/^\$scrypt\$ln=15,r=8,p=1\$[A-Za-z0-9+/]+\$[A-Za-z0-9+/]+$/);
+    expect(a).not.toBe(b);
+  });
+
+  test("encodes the parameters and key it was derived with", async () => {
+    const h = await hashPassword("correct horse");
+    const [, , params, salt, key] = h.split("$");
+    expect(params).toBe("ln=15,r=8,p=1");
+    const want = scryptSync("correct horse", Buffer.from(salt, "base64"), 32, {
+      N: 2 ** 15,
+      r: 8,
+      p: 1,
+      maxmem: 64 * 1024 * 1024
+    });
+    expect(Buffer.from(key, "base64").equals(want)).toBe(true);
+  });
+});
@@ -0,0 +1,51 @@
+import { describe, expect, test } from "vitest";
+import type { UrlRecord } from "../../src/storage.js";
+import { isPending, publicUrlRecordResponse, urlRecordResponse } from "../../src/url_record.js";
+
+const base: UrlRecord = {
+  code: "abc",
+  namespace: "",
+  longUrl: "https://example.com/secret",
+  createdAt: "2024-03-01T12:00:00.000Z",
+  fallbackUrl: "https://example.com/fallback",
+  rules: [{ variant: "ios", url: "https://example.com/ios", platforms: ["ios"] }],
+  destinations: [{ variant: "a", url: "https://example.com/a", weight: 1 }]
+};
+
+describe("publicUrlRecordResponse", () => {
+  test("returns the full record for an ordinary link", () => {
+    expect(publicUrlRecordResponse(base)).toEqual(urlRecordResponse(base));
+  });
+
+  test("withholds a protected link's destinations", () => {
+    const res = publicUrlRecordResponse({ ...base, passwordHash: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA" });
+    expect(res).toEqual({ code: "abc", created_at: base.createdAt, protected: true });
+    expect(JSON.stringify(res)).not.toContain("example.com");
+  });
+
+  test("withholds a click-limited link's destinations", () => {
+    const res = publicUrlRecordResponse({ ...base, maxUses: 1 });
+    expect(res).toEqual({ code: "abc", created_at: base.createdAt, max_uses: 1 });
+    expect(JSON.stringify(res)).not.toContain("example.com");
+  });
+});
+
+describe("urlRecordResponse", () => {
+  test("flags protected links without returning the hash", () => {
+    const res = urlRecordResponse({ ...base, passwordHash: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA" });
+    expect(res.long_url).toBe(base.longUrl);
+    expect(res.protected).toBe(true);
+    expect(JSON.stringify(res)).not.toContain("scrypt");
+  });
+});
+
+describe("isPending", () => {
+  const now = new Date("2024-03-01T12:00:00Z");
+
+  test("is true only before not_before", () => {
+    expect(isPending(base, now)).toBe(false);
+    expect(isPending({ ...base, notBefore: "2024-03-02T00:00:00Z" }, now)).toBe(true);
+    expect(isPending({ ...base, notBefore: "2024-03-01T12:00:00Z" }, now)).toBe(false);
+    expect(isPending({ ...base, notBefore: "2024-02-01T00:00:00Z" }, now)).toBe(false);
+  });
+});